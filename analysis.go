@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sdboyer/gps"
+	"github.com/sdboyer/gta/sweep"
+)
+
+// solutionShape returns the sorted list of projects, and the versions they
+// were resolved to, in a solution - excluding the focus projects themselves.
+//
+// Two solutions with the same shape differ only in the versions of the focus
+// projects.
+func solutionShape(s gps.Solution, focus sweep.Combo) []string {
+	var shape []string
+	for _, p := range s.Projects() {
+		id := p.Ident()
+		if focus.Has(id.ProjectRoot) {
+			continue
+		}
+
+		shape = append(shape, fmt.Sprintf("%s@%s", ppi(id), p.Version()))
+	}
+
+	sort.Strings(shape)
+	return shape
+}
+
+// printShapes groups the successfully solved versions by the shape of their
+// solutions, and prints each group, along with how it differs from the group
+// that preceded it.
+func printShapes(results []sweep.Result) {
+	type group struct {
+		shape []string
+		cs    []sweep.Combo
+	}
+
+	var groups []*group
+	idx := make(map[string]*group)
+	for _, r := range results {
+		if r.SolveErr != nil {
+			continue
+		}
+
+		shape := solutionShape(r.Solution, r.Combo)
+		key := strings.Join(shape, "\n")
+		g, has := idx[key]
+		if !has {
+			g = &group{shape: shape}
+			idx[key] = g
+			groups = append(groups, g)
+		}
+		g.cs = append(g.cs, r.Combo)
+	}
+
+	if len(groups) == 0 {
+		return
+	}
+
+	fmt.Fprintf(hout, "Solutions had %v distinct shape(s), ignoring the versions of the focus dependencies:\n", len(groups))
+	for k, g := range groups {
+		fmt.Fprintf(hout, "  %v dep(s) with:\n", len(g.shape))
+		printCombos(g.cs)
+		if k == 0 {
+			continue
+		}
+
+		added, removed := diffShapes(groups[k-1].shape, g.shape)
+		for _, p := range added {
+			fmt.Fprintf(hout, "\t+ %s\n", p)
+		}
+		for _, p := range removed {
+			fmt.Fprintf(hout, "\t- %s\n", p)
+		}
+	}
+	fmt.Fprintln(hout, "")
+}
+
+// printUnexercised lists the deps, other than the focus ones, that were solved
+// to the same version for every version that solved: whatever the focus
+// versions were, those were only ever checked against the one version of
+// each, so the sweep says nothing about any others. Deps that only some
+// solutions had count, if they had the same version in all of those. Nothing
+// is printed unless at least two versions solved.
+func printUnexercised(results []sweep.Result) {
+	type seen struct {
+		id gps.ProjectIdentifier
+		vs map[string]bool
+		v  gps.Version
+	}
+
+	var nsolved int
+	deps := make(map[gps.ProjectRoot]*seen)
+	for _, r := range results {
+		if r.SolveErr != nil || r.Solution == nil {
+			continue
+		}
+		nsolved++
+		for _, p := range r.Solution.Projects() {
+			id := p.Ident()
+			if r.Combo.Has(id.ProjectRoot) {
+				continue
+			}
+			d, has := deps[id.ProjectRoot]
+			if !has {
+				d = &seen{id: id, vs: make(map[string]bool), v: p.Version()}
+				deps[id.ProjectRoot] = d
+			}
+			// Tags on the same revision are the same code
+			key := p.Version().String()
+			if pv, ok := p.Version().(gps.PairedVersion); ok {
+				key = pv.Underlying().String()
+			}
+			d.vs[key] = true
+		}
+	}
+	if nsolved < 2 {
+		return
+	}
+
+	var constant []string
+	for _, d := range deps {
+		if len(d.vs) == 1 {
+			constant = append(constant, fmt.Sprintf("%s@%s", ppi(d.id), d.v))
+		}
+	}
+	if len(constant) == 0 {
+		return
+	}
+
+	sort.Strings(constant)
+	fmt.Fprintf(hout, "Unexercised (constant) deps, at the same version in all %v solutions, and so never checked at any other:\n", nsolved)
+	for _, c := range constant {
+		fmt.Fprintf(hout, "\t%s\n", c)
+	}
+	fmt.Fprintln(hout, "Widen the constraints on them (or check them as deps in their own right) to cover more.")
+	fmt.Fprintln(hout, "")
+}
+
+// diffShapes reports the entries present in b but not a, and those present in
+// a but not b.
+func diffShapes(a, b []string) (added, removed []string) {
+	am := make(map[string]bool, len(a))
+	for _, p := range a {
+		am[p] = true
+	}
+	bm := make(map[string]bool, len(b))
+	for _, p := range b {
+		bm[p] = true
+		if !am[p] {
+			added = append(added, p)
+		}
+	}
+	for _, p := range a {
+		if !bm[p] {
+			removed = append(removed, p)
+		}
+	}
+
+	return
+}
+
+// requirements returns the sorted constraints that the versions in a combo
+// declare on their own deps, as root@constraint.
+func requirements(sm gps.SourceManager, c sweep.Combo) ([]string, error) {
+	var reqs []string
+	for _, av := range c {
+		m, _, err := sm.GetManifestAndLock(gps.ProjectIdentifier{ProjectRoot: av.Root}, av.Version)
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			continue
+		}
+		for _, d := range m.DependencyConstraints() {
+			reqs = append(reqs, fmt.Sprintf("%s@%s", d.Ident.ProjectRoot, d.Constraint))
+		}
+	}
+
+	sort.Strings(reqs)
+	return reqs, nil
+}
+
+// declares reports whether the project id, at at, lists root among its deps in
+// its manifest. If that can't be told, because at isn't a single version, or
+// the manifest can't be had, it's given the benefit of the doubt.
+func declares(sm gps.SourceManager, id gps.ProjectIdentifier, at gps.Constraint, root gps.ProjectRoot) bool {
+	v, ok := at.(gps.Version)
+	if !ok {
+		return true
+	}
+	m, _, err := sm.GetManifestAndLock(id, v)
+	if err != nil || m == nil {
+		return true
+	}
+	for _, d := range m.DependencyConstraints() {
+		if d.Ident.ProjectRoot == root {
+			return true
+		}
+	}
+	return false
+}
+
+// printFailureDiff tries to explain why a combo failed to solve, when the one
+// before it succeeded. A failed solve has no solution to compare, so instead
+// it prints how the focus projects' requirements changed between the two, and
+// what the successful solution picked for each project whose requirement was
+// added or changed.
+func printFailureDiff(sm gps.SourceManager, ok, failed sweep.Result, ids map[gps.ProjectRoot]gps.ProjectIdentifier) {
+	before, err := requirements(sm, ok.Combo)
+	var after []string
+	if err == nil {
+		after, err = requirements(sm, failed.Combo)
+	}
+	if err != nil {
+		fmt.Fprintf(hout, "\tCould not compare with %s, which solved: %s\n", ppc(ok.Combo, ids), err)
+		return
+	}
+
+	added, removed := diffShapes(before, after)
+	if len(added) == 0 && len(removed) == 0 {
+		fmt.Fprintf(hout, "\tRequirements are unchanged from %s, which solved\n", ppc(ok.Combo, ids))
+		return
+	}
+
+	picked := make(map[gps.ProjectRoot]gps.Version)
+	for _, p := range ok.Solution.Projects() {
+		picked[p.Ident().ProjectRoot] = p.Version()
+	}
+
+	fmt.Fprintf(hout, "\tRequirements changed from %s, which solved:\n", ppc(ok.Combo, ids))
+	for _, p := range added {
+		root := gps.ProjectRoot(p[:strings.Index(p, "@")])
+		if v, has := picked[root]; has {
+			fmt.Fprintf(hout, "\t+ %s (solved with %s)\n", p, v)
+		} else {
+			fmt.Fprintf(hout, "\t+ %s\n", p)
+		}
+	}
+	for _, p := range removed {
+		fmt.Fprintf(hout, "\t- %s\n", p)
+	}
+}
+
+// printConflicts lists the pairs of constraints that could not be reconciled
+// in a failed solve, if its error can be broken down.
+func printConflicts(err error) {
+	f := sweep.ParseSolveError(err)
+	if f == nil {
+		return
+	}
+
+	var n int
+	for _, rv := range f.Rejected {
+		for _, c := range rv.Conflicts {
+			if n == 0 {
+				fmt.Fprintf(hout, "\tConflicts found while looking for a version of %s:\n", f.Project)
+			}
+			n++
+			fmt.Fprintf(hout, "\t  %s: %s wants %s, but %s wants %s\n", c.Dep, c.Depender, c.Constraint, c.ExistingFrom, c.Existing)
+		}
+	}
+}
+
+// printBisection reports what --bisect found, for the target named name.
+func printBisection(b *sweep.Bisection, name string) {
+	fmt.Fprintf(hout, "Bisected the %v versions of %s with %v checks:\n", len(b.Versions), name, len(b.Results))
+	if b.FirstFail != nil {
+		fmt.Fprintf(hout, "\tthe last version to pass was %s, and the first to fail %s\n", b.LastPass, b.FirstFail)
+	} else {
+		first, last := b.Versions[0], b.Versions[len(b.Versions)-1]
+		outcome := "passed"
+		if len(b.Results) > 0 && b.Results[0].Status() != sweep.StatusPass {
+			outcome = "failed"
+		}
+		fmt.Fprintf(hout, "\tthe versions at both ends, %s and %s, %s, so there was no change to find\n", first, last, outcome)
+	}
+	fmt.Fprintln(hout, "This assumes the versions pass up to some point, and fail from there on (or the other way round); if the results look otherwise, run a full sweep without --bisect.")
+	fmt.Fprintln(hout, "")
+}
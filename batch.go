@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sdboyer/gps"
+)
+
+// runBatch checks each dep listed on stdin in turn, as --batch, writing a line
+// of JSON for each as soon as it's done. Each line of input is a package and,
+// optionally, a constraint, as for --override; blank lines, and lines starting
+// with #, are skipped. Every dep is checked with sm.
+//
+// A dep that fails, or can't be checked at all, doesn't stop the batch; only
+// an interruption does. The error returned counts the deps that did either.
+func runBatch(args []string, sm gps.SourceManager) error {
+	if len(args) > 0 {
+		return fmt.Errorf("--batch reads the deps to check from stdin, so none can be given as arguments")
+	}
+	if isTerminal(os.Stdin) {
+		return fmt.Errorf("--batch reads the deps to check from stdin, which is a terminal; pipe them in")
+	}
+	if branch != "" || version != "" || semver != "" || len(versions) > 0 || len(revisions) > 0 || allBranches {
+		return fmt.Errorf("--batch takes each dep's constraint from its line of input, so the constraint flags can't be used with it")
+	}
+	switch {
+	case format != "text" && format != "json":
+		return fmt.Errorf("--batch writes a line of JSON per dep, so it can't be used with --format %s", format)
+	case junit != "":
+		return fmt.Errorf("--batch can't be used with --junit, as each dep would overwrite the last one's report")
+	case summaryJSON != "":
+		return fmt.Errorf("--batch can't be used with --summary-json, as each dep would overwrite the last one's report; its output has the same in it")
+	case listOnly:
+		return fmt.Errorf("--batch can't be used with --list-only")
+	case confirm:
+		return fmt.Errorf("--batch can't be used with --confirm, as stdin is taken up by the deps to check")
+	case changedOnly && changedFile == "":
+		return fmt.Errorf("--batch reads the deps to check from stdin, so --changed-only needs the changed paths in a --changed-file")
+	}
+	format = "json"
+
+	enc := json.NewEncoder(os.Stdout)
+	var n, nfailed int
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		n++
+
+		bl := &batchLine{}
+		fields := strings.Fields(line)
+		bl.Dependency = fields[0]
+		if len(fields) > 1 {
+			bl.Constraint = fields[1]
+		}
+
+		var err error
+		if len(fields) > 2 {
+			err = fmt.Errorf("%q is not of the form \"pkg [constraint]\"", line)
+		} else {
+			branch, version, semver = "", "", ""
+			if bl.Constraint != "" {
+				branch, version, semver = splitConstraint(bl.Constraint)
+			}
+			err = checkDeps([]string{bl.Dependency}, sm, bl)
+		}
+		if err == errInterrupted {
+			return err
+		}
+		if err != nil {
+			nfailed++
+			// Failures are in the report already
+			if _, ok := err.(failedError); !ok || bl.jsonReport == nil {
+				bl.Error = err.Error()
+			}
+		}
+
+		if err = enc.Encode(bl); err != nil {
+			return fmt.Errorf("Failed to write JSON output: %s", err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("Could not read the deps to check: %s", err)
+	}
+
+	if nfailed > 0 {
+		return failedError{
+			n:   nfailed,
+			msg: fmt.Sprintf("%v/%v deps in the batch failed, or could not be checked", nfailed, n),
+		}
+	}
+	return nil
+}
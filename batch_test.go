@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sdboyer/gps"
+)
+
+// dedSM is a SourceManager that records the import paths it's asked to deduce
+// roots for, and fails to deduce any, which stops a check right there.
+type dedSM struct {
+	gps.SourceManager
+	deduced []string
+}
+
+func (sm *dedSM) DeduceProjectRoot(ip string) (gps.ProjectRoot, error) {
+	sm.deduced = append(sm.deduced, ip)
+	return "", fmt.Errorf("no source for %s", ip)
+}
+
+func TestBatchSharesSourceManager(t *testing.T) {
+	defer func(ip string, np bool, f, c, sb string, ma int, h io.Writer, b, v, s string) {
+		importPath, noPM, format, color, sortBy, maxAttempts, hout, branch, version, semver = ip, np, f, c, sb, ma, h, b, v, s
+	}(importPath, noPM, format, color, sortBy, maxAttempts, hout, branch, version, semver)
+	defer func(in, out *os.File) { os.Stdin, os.Stdout = in, out }(os.Stdin, os.Stdout)
+
+	proj, err := ioutil.TempDir("", "gta-batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(proj)
+	writeFile(t, filepath.Join(proj, "main.go"), "package main\n\nfunc main() {}\n")
+	writeFile(t, filepath.Join(proj, "deps"), "github.com/foo/bar\n# not a dep\n\ngithub.com/foo/baz ^1.0.0\n")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err = os.Chdir(proj); err != nil {
+		t.Fatal(err)
+	}
+	// The flags' defaults are only set up by main
+	importPath, noPM = "example.com/proj", true
+	format, color, sortBy, maxAttempts = "json", "never", "version", 1
+
+	if os.Stdin, err = os.Open(filepath.Join(proj, "deps")); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Stdin.Close()
+	if os.Stdout, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Stdout.Close()
+
+	sm := &dedSM{}
+	err = runBatch(nil, sm)
+	if fe, ok := err.(failedError); !ok || fe.n != 2 {
+		t.Errorf("runBatch returned %v; want both deps to have failed", err)
+	}
+	if want := []string{"github.com/foo/bar", "github.com/foo/baz"}; !reflect.DeepEqual(sm.deduced, want) {
+		t.Errorf("the SourceManager given was asked about %q; want every dep in the batch, %q", sm.deduced, want)
+	}
+}
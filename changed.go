@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/sdboyer/gps"
+)
+
+// readChanged reads the list of changed paths for --changed-only, one per
+// line. Blank lines, and lines starting with #, are skipped.
+//
+// Paths are usually import paths, but file paths from e.g. git diff
+// --name-only will do for vendored deps: anything up to and including the
+// last vendor dir is dropped, which leaves the import path of the file's
+// package, with the file on the end.
+func readChanged(r io.Reader) ([]string, error) {
+	var changed []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p := filepath.ToSlash(line)
+		if p == "vendor" || strings.HasPrefix(p, "vendor/") {
+			p = "/" + p
+		}
+		if k := strings.LastIndex(p, "/vendor/"); k >= 0 {
+			p = p[k+len("/vendor/"):]
+		}
+		changed = append(changed, strings.TrimSuffix(p, "/"))
+	}
+	return changed, sc.Err()
+}
+
+// touches reports whether any of the changed paths is in the project at root.
+func touches(changed []string, root gps.ProjectRoot) bool {
+	for _, p := range changed {
+		if p == string(root) || strings.HasPrefix(p, string(root)+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+var CleanCmd = &cobra.Command{
+	Use:   "clean [dir...]",
+	Short: "Remove what was left behind by gta runs that were interrupted",
+	Long: `clean removes the debris that an interrupted gta run can leave in the current
+directory: vend-* trees kept by --keep-vendor. Any other dirs given (e.g. a
+--keep-vendor dir) are cleaned of vend-* trees, too.
+
+An _origvendor backup of the vendor dir is never removed by default, as it's
+probably the only copy of the original vendor dir; if a run was killed while a
+command was running, vendor is a tree written by gta. Running gta with
+--force-restore puts the backup back in place. If you're sure it's not wanted,
+--discard-backup removes it, too.`,
+	RunE: RunClean,
+}
+
+func RunClean(cmd *cobra.Command, args []string) error {
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("Could not get working directory: %s", err)
+	}
+
+	var rm []string
+	ovpath := filepath.Join(wd, "_origvendor")
+	if _, err = os.Stat(ovpath); err == nil {
+		if discardBackup {
+			rm = append(rm, ovpath)
+		} else {
+			fmt.Fprintf(hout, "Leaving %s, as it's probably your original vendor dir; gta --force-restore will put it back, or pass --discard-backup to remove it\n", ovpath)
+		}
+	}
+
+	for _, dir := range append([]string{wd}, args...) {
+		vends, err := filepath.Glob(filepath.Join(dir, "vend-*"))
+		if err != nil {
+			return err
+		}
+		rm = append(rm, vends...)
+	}
+
+	if len(rm) == 0 {
+		fmt.Fprintln(hout, "Nothing to clean up.")
+		return nil
+	}
+
+	for _, p := range rm {
+		if dryRun {
+			fmt.Fprintf(hout, "Would remove %s\n", p)
+			continue
+		}
+		fmt.Fprintf(hout, "Removing %s\n", p)
+		if err = os.RemoveAll(p); err != nil {
+			return fmt.Errorf("Could not remove %s: %s", p, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import "github.com/sdboyer/gta/sweep"
+
+// colorize is whether human output should be colored, as decided from
+// --color.
+var colorize bool
+
+// ANSI SGR color codes
+const (
+	red    = "31"
+	green  = "32"
+	yellow = "33"
+)
+
+// paint wraps s in the escape codes for color, if output is being colorized.
+func paint(color, s string) string {
+	if !colorize {
+		return s
+	}
+	return "\x1b[" + color + "m" + s + "\x1b[0m"
+}
+
+// paintStatus paints s in the color for st.
+func paintStatus(st sweep.Status, s string) string {
+	switch st {
+	case sweep.StatusFail:
+		return paint(red, s)
+	case sweep.StatusError:
+		return paint(yellow, s)
+	}
+	return paint(green, s)
+}
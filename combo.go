@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sdboyer/gps"
+	"github.com/sdboyer/gta/sweep"
+)
+
+// countCombos returns the number of combos that would be produced from the
+// given targets.
+func countCombos(targets []sweep.Target) int {
+	n := 1
+	for _, t := range targets {
+		n *= len(t.Versions)
+	}
+	return n
+}
+
+// printCombos prints a list of combos, in a way that's compact when only a
+// single dependency is being checked.
+func printCombos(cs []sweep.Combo) {
+	if len(cs) > 0 && len(cs[0]) == 1 {
+		vl := make([]gps.Version, len(cs))
+		for k, c := range cs {
+			vl[k] = c[0].Version
+		}
+		fmt.Fprintf(hout, "\t%s\n", vl)
+		return
+	}
+
+	for _, c := range cs {
+		fmt.Fprintf(hout, "\t%s\n", c)
+	}
+}
+
+// ppi formats a project identifier for output: the root, and, if the project
+// comes from a source other than the one at that root (say, a fork), where.
+func ppi(id gps.ProjectIdentifier) string {
+	if id.NetworkName == "" || id.NetworkName == string(id.ProjectRoot) {
+		return string(id.ProjectRoot)
+	}
+	return fmt.Sprintf("%s (from %s)", id.ProjectRoot, id.NetworkName)
+}
+
+// ppc formats a combo for output as ppi does each project in it, with ids
+// giving the identifiers of the targets.
+func ppc(c sweep.Combo, ids map[gps.ProjectRoot]gps.ProjectIdentifier) string {
+	s := make([]string, len(c))
+	for k, av := range c {
+		s[k] = av.String()
+		if id := ids[av.Root]; id.NetworkName != "" && id.NetworkName != string(id.ProjectRoot) {
+			s[k] += fmt.Sprintf(" (from %s)", id.NetworkName)
+		}
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// configFileName is the name of the file, in the working directory, from which
+// defaults for flags are read.
+const configFileName = ".gta.yaml"
+
+// applyConfig sets the flags of cmd from the config file in the working
+// directory, if there is one. Keys are flag names, without the dashes; a list
+// sets a repeatable flag once per element. Flags given on the command line
+// take precedence, so those are left alone.
+//
+// Keys for flags that only the main command has are ignored by the
+// subcommands, so that one file can serve them all.
+func applyConfig(cmd *cobra.Command, args []string) error {
+	path := filepath.Join(".", configFileName)
+	yml, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	// Errors from here couldn't have been caused by the usage
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+	if err != nil {
+		return fmt.Errorf("Could not read config file %s: %s", path, err)
+	}
+
+	var conf yaml.MapSlice
+	if err = yaml.Unmarshal(yml, &conf); err != nil {
+		return fmt.Errorf("Could not parse config file %s: %s", path, err)
+	}
+
+	for _, item := range conf {
+		name := fmt.Sprint(item.Key)
+		f := cmd.Flags().Lookup(name)
+		if f == nil {
+			if cmd == RootCmd || RootCmd.Flags().Lookup(name) == nil {
+				return fmt.Errorf("Config file %s sets %q, which is not a flag", path, name)
+			}
+			continue
+		}
+		if f.Changed {
+			continue
+		}
+
+		vals := []interface{}{item.Value}
+		if l, ok := item.Value.([]interface{}); ok {
+			vals = l
+		}
+		for _, v := range vals {
+			if err = cmd.Flags().Set(name, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("Config file %s has a bad value for %s: %s", path, name, err)
+			}
+		}
+	}
+	return nil
+}
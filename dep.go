@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sdboyer/gps"
+)
+
+const (
+	depManifestFile = "Gopkg.toml"
+	depLockFile     = "Gopkg.lock"
+)
+
+// depManifest is a root manifest read from dep's Gopkg.toml.
+type depManifest struct {
+	deps []gps.ProjectConstraint
+	ovr  gps.ProjectConstraints
+	ig   map[string]bool
+}
+
+func (m depManifest) DependencyConstraints() []gps.ProjectConstraint {
+	return m.deps
+}
+
+func (m depManifest) TestDependencyConstraints() []gps.ProjectConstraint {
+	return nil
+}
+
+func (m depManifest) Overrides() gps.ProjectConstraints {
+	return m.ovr
+}
+
+func (m depManifest) IgnorePackages() map[string]bool {
+	return m.ig
+}
+
+func hasDep(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, depManifestFile))
+	return err == nil
+}
+
+// loadDep builds a manifest from dep's Gopkg.toml, and a lock from its
+// Gopkg.lock, if there is one. dep's metadata maps onto gps's directly, as dep
+// is built on gps.
+func loadDep(dir string) (gps.Manifest, gps.Lock, error) {
+	tables, err := readTOML(filepath.Join(dir, depManifestFile))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m := depManifest{
+		ovr: make(gps.ProjectConstraints),
+		ig:  make(map[string]bool),
+	}
+	for _, t := range tables {
+		switch {
+		case t.name == "":
+			for _, path := range t.strs("ignored") {
+				m.ig[path] = true
+			}
+		case t.array && (t.name == "constraint" || t.name == "override"):
+			name := t.str("name")
+			if name == "" {
+				return nil, nil, fmt.Errorf("%s: a [[%s]] is missing its name", depManifestFile, t.name)
+			}
+			c, err := depConstraint(t)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %s: %s", depManifestFile, name, err)
+			}
+
+			pp := gps.ProjectProperties{
+				NetworkName: t.str("source"),
+				Constraint:  c,
+			}
+			if t.name == "override" {
+				m.ovr[gps.ProjectRoot(name)] = pp
+				continue
+			}
+			if c == nil {
+				c = gps.Any()
+			}
+			m.deps = append(m.deps, gps.ProjectConstraint{
+				Ident: gps.ProjectIdentifier{
+					ProjectRoot: gps.ProjectRoot(name),
+					NetworkName: pp.NetworkName,
+				},
+				Constraint: c,
+			})
+		}
+	}
+
+	tables, err = readTOML(filepath.Join(dir, depLockFile))
+	if os.IsNotExist(err) {
+		return m, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	var l gps.SimpleLock
+	for _, t := range tables {
+		if !t.array || t.name != "projects" {
+			continue
+		}
+
+		name, rev := t.str("name"), t.str("revision")
+		if name == "" || rev == "" {
+			return nil, nil, fmt.Errorf("%s: a [[projects]] is missing its name or revision", depLockFile)
+		}
+
+		var v gps.Version = gps.Revision(rev)
+		if ver := t.str("version"); ver != "" {
+			v = gps.NewVersion(ver).Is(gps.Revision(rev))
+		} else if b := t.str("branch"); b != "" {
+			v = gps.NewBranch(b).Is(gps.Revision(rev))
+		}
+
+		id := gps.ProjectIdentifier{
+			ProjectRoot: gps.ProjectRoot(name),
+			NetworkName: t.str("source"),
+		}
+		l = append(l, gps.NewLockedProject(id, v, nil))
+	}
+
+	return m, l, nil
+}
+
+func readTOML(path string) ([]*tomlTable, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tables, err := parseTOML(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %s", filepath.Base(path), err)
+	}
+	return tables, nil
+}
+
+// depConstraint converts the version, branch, or revision in a [[constraint]]
+// or [[override]] into a gps.Constraint, following dep's rules: a bare semver
+// version means a caret range, so "1.2.0" allows anything from 1.2.0 up to, but
+// not including, 2.0.0. With none of the three, the constraint is nil.
+func depConstraint(t *tomlTable) (gps.Constraint, error) {
+	ver, branch, rev := t.str("version"), t.str("branch"), t.str("revision")
+
+	var n int
+	for _, s := range []string{ver, branch, rev} {
+		if s != "" {
+			n++
+		}
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("only one of version, branch, and revision may be given")
+	}
+
+	switch {
+	case branch != "":
+		return gps.NewBranch(branch), nil
+	case rev != "":
+		return gps.Revision(rev), nil
+	case ver != "":
+		body := ver
+		if c := strings.TrimPrefix(ver, "v"); c != "" && c[0] >= '0' && c[0] <= '9' {
+			body = "^" + ver
+		}
+		if c, err := gps.NewSemverConstraint(body); err == nil {
+			return c, nil
+		}
+		// Not semver, so it must be a plain tag
+		return gps.NewVersion(ver), nil
+	}
+
+	return nil, nil
+}
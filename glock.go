@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/glide/cfg"
+	"github.com/Masterminds/glide/util"
+	"github.com/sdboyer/gps"
+)
+
+const glockFile = "GLOCKFILE"
+
+func hasGlock(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, glockFile))
+	return err == nil
+}
+
+// loadGlock builds a manifest and lock from a GLOCKFILE, which pins each dep
+// with a line of the form "import/path revision". Like godep, glock has no
+// notion of constraints, so the manifest only names the deps, and the
+// revisions in the lock are used as preferred versions.
+//
+// Lines naming commands to be installed ("cmd import/path") are skipped.
+func loadGlock(dir string) (gps.Manifest, gps.Lock, error) {
+	f, err := os.Open(filepath.Join(dir, glockFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var d cfg.Dependencies
+	l := &cfg.Lockfile{}
+	seen := make(map[string]bool)
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || fields[0] == "cmd" {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("%s:%v: expected an import path and a revision", glockFile, n)
+		}
+
+		pkg, _ := util.NormalizeName(fields[0])
+		if seen[pkg] {
+			continue
+		}
+		seen[pkg] = true
+
+		d = append(d, &cfg.Dependency{Name: pkg})
+		l.Imports = append(l.Imports, &cfg.Lock{Name: pkg, Revision: fields[1]})
+	}
+	if err = sc.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return &cfg.Config{Name: dir, Imports: d}, l, nil
+}
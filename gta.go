@@ -1,17 +1,29 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
-	"go/build"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/Masterminds/glide/dependency"
 	gpath "github.com/Masterminds/glide/path"
+	msemver "github.com/Masterminds/semver"
 	"github.com/sdboyer/gps"
+	"github.com/sdboyer/gta/sweep"
 	"github.com/spf13/cobra"
 )
 
@@ -30,51 +42,518 @@ $ gta github.com/foo/bar
 By default, gta will simply determine if a dependency solution exists that's
 viable for each dep version. However, if a value is passed for --run, then
 gta will also execute that command for each solution. ` + "`go test`" + ` is usually
-the simplest useful command to run here.
+the simplest useful command to run here. --run may be repeated, in which case
+the commands are run in turn, and a version fails at the first one that does:
+
+$ gta -r "go build" -r "go test" github.com/foo/bar
+
+For each version that fails, gta prints a command that checks just it again,
+with the same flags, quoted for the shell, to be copied and pasted while
+debugging it. Flags that pick the versions, or write reports, are left out.
+
+Multiple dependencies may be given. gta will then check every combination of
+their versions (subject to --max-combos), which is useful for deps that tend to
+move together:
+
+$ gta github.com/foo/client github.com/foo/transport
+
+A dep may also be checked against a local git repository, such as a fork that
+hasn't been pushed, by giving it as <import path>=<dir>. Its local branches and
+tags are the versions checked, and whatever is checked out is left alone. The
+import path must be the one the project imports the dep by - for a fork,
+usually the original's, not the fork's own - as that's where its trees are
+vendored. A dir alone will do if it's checked out on a GOPATH, but then its
+import path is taken from where it is, which for a fork is rarely right:
+
+$ gta -r "go test" github.com/foo/bar=../bar-fork
+
+The --run command may refer to the version being checked, as {{.Version}}, and
+its project root, as {{.Root}}; when checking multiple deps, these are for the
+first one given, and {{index .Versions "github.com/foo/bar"}} gives any of them:
+
+$ gta -r "go test -ldflags '-X main.barVersion={{.Version}}'" github.com/foo/bar
+
+The same are also set in the command's environment, as GTA_DEP_VERSION and
+GTA_DEP_ROOT, along with anything given with --env.
+
+Setup that the commands need, such as generating code or starting a fixture,
+can be given with --pre-run, which is run once for each version, after its tree
+is written and before --run. If it fails, --run isn't run, and the version is
+reported as an error, at the pre-run stage; like a tree that can't be written,
+that counts as a failure, in the exit status and in TAP and JUnit reports. --post-run is run after --run, for teardown, whatever happened; if
+it fails, that's only warned of. Both are run in the same dir and environment
+as --run, but aren't templates, so they get the version from $GTA_DEP_VERSION:
+
+$ gta --pre-run "./fixture up" --post-run "./fixture down" -r "go test ./..." github.com/foo/bar
+
+Some test runners exit 0 even when things went wrong. For those, a version can
+also be made to pass only if the combined output of its commands matches
+--success-regexp, or to fail if it matches --fail-regexp, once every command
+has exited 0; the output is matched as a whole, so use (?m) for ^ and $ to
+match at each line:
+
+$ gta -r "./run-tests.sh" --fail-regexp '(?m)^(FAIL|SKIP ALL)' github.com/foo/bar
+
+A dep's compatibility can hinge on code behind build tags. --build-tags gives
+the go tool -tags for every --run command, by way of GOFLAGS, which go commands
+honor wherever they sit in the command (Go 1.11 and up); it takes the tags
+comma- or space-separated, as -tags does. It's added to whatever GOFLAGS the
+command would otherwise get - that given with --env, if any, or else the one
+gta was run with - and any tags those already had are kept:
+
+$ gta -r "go test ./..." --build-tags integration,linux github.com/foo/bar
 
 Unless --no-pm is specified, gta will try to detect if metadata files for
-package managers are present (it works best with glide, but may work with
-others). If so, rather than testing all possible versions of the dependency, it
+package managers are present (it works best with glide or dep, but may work
+with others). If so, rather than testing all possible versions of the dependency, it
 will only check versions that are allowed by the constraints specified in those
-files.`,
+files.
+
+With --prefer-lowest, versions are checked oldest first, and the solver prefers
+the lowest acceptable versions of all the other deps, too - useful for catching
+accidental use of newer APIs. Deps pinned in a lock file still get their locked
+versions, though; use --no-pm to let everything float down.
+
+The lock is only a preference to the solver, which moves other deps off their
+locked versions if the version being checked requires it. With --pin-others,
+every dep in the lock, other than those being checked, gets an override for
+exactly its locked version, so a version that needs anything else to change
+fails to solve; whatever then fails can only be down to the dep being checked.
+
+--lock-as-floor asks whether the project can upgrade from where it is: each dep
+is checked at every semver version from the one it's locked to upward, whatever
+the project's manifest allows (--semver can still narrow that). A dep locked to
+a revision is placed at the semver tag that points at it; one locked to a
+branch, or to a tag that isn't semver, can't be placed, and is an error.
+
+A version that fails to solve is normally not run at all. For diagnostics,
+--run-on-solve-failure runs it anyway, against a tree made from the lock, with
+the dep at the version being checked - gps gives back nothing from a failed
+solve to build a better one from. Those runs are labeled as such, and the
+version still counts as failed, however the run goes.
+
+With --with-test=false, the constraints that the project's metadata puts on its
+test-only deps (e.g. glide's testImport) are left out, so that the non-test
+build can be checked on its own; those deps are still solved for, but float
+freely. A dep being checked always gets exactly the version being checked,
+whether or not it's test-only, and whatever its test constraint says.
+
+Checking a dep that the project doesn't import yet draws a warning, as it's
+usually a mistake. To evaluate adding one, pass --add, and give a package of it
+that has Go code in it; the solver then treats the project as importing that
+package, so the dep is solved for along with the rest:
+
+$ gta --add github.com/foo/newdep
+
+--mod (experimental) is for projects that build with Go modules. Versions are
+still solved as usual, but each solution is run in a copy of the project whose
+go.mod requires exactly the projects in it, each replaced with a local copy of
+the version solved for; no vendor tree is written. The go tool isn't allowed
+onto the network (GOPROXY=off), so anything the solution doesn't cover fails
+the run, rather than being fetched behind gta's back.
+
+The vendor trees written for --run are flattened: any vendor dirs that deps
+carry of their own are removed, so that every package builds against the one
+version of each dep in the solution, rather than whatever copy a dep happened
+to vendor. --no-strip-vendor leaves them in place, for deps whose nested vendor
+trees matter (and to see what a build that honors them would do); but note
+that a type from a nested copy is then distinct from the same type in the
+solution's copy.
+
+--bisect finds where a dep's versions start to fail, rather than checking
+every one: the newest and oldest are checked first, and then the versions
+between them are halved until the two adjacent versions either side of the
+change are found. That takes about log2(n) checks of n versions, and assumes
+that each version passes up to the change, and fails after it (or the other
+way round); if the results don't bear that out, run a full sweep.
+
+Sometimes the question is which versions of one of a dep's own deps work with
+it. --via names the dep in between, which is held at the version it's locked
+to (or at one given, as root@constraint, in the same form as for --override)
+for the whole sweep, while the deps given are checked beneath it. Those are put
+at each version with an override, rather than the project's own constraint, so
+they needn't be imported by the project, and what the dep in between asks of
+them is overridden:
+
+$ gta --via github.com/foo/client github.com/foo/transport
+
+Overrides for any project in the depgraph may be supplied in a
+.gta-overrides.toml file in the project root, or failing that a
+.gta-overrides.yaml (or a file named by --overrides-file, read as TOML if its
+name ends in .toml). These are applied only for the duration of the run. The
+TOML file has the same format as the [[override]]s in dep's Gopkg.toml:
+
+  [[override]]
+    name = "github.com/foo/baz"
+    source = "https://github.com/me/baz"
+    branch = "fix-thing"
+
+and the YAML file the same format as glide.yaml's dependencies, under an
+"overrides" key:
+
+  overrides:
+  - package: github.com/foo/baz
+    repo: https://github.com/me/baz
+    branch: fix-thing
+
+Overrides may also be given with --override, as root@constraint, where the
+constraint is a semver range, or branch=<name> or version=<tag>:
+
+$ gta --override github.com/foo/baz@^1.2.0 --override github.com/foo/qux@branch=fix github.com/foo/bar
+
+Overrides apply to the solve for every version checked. Those given with
+--override take precedence over those from the file, which in turn take
+precedence over any declared in the project's own manifest.
+
+When a dep's own requirements changed between its versions, an override may be
+needed for some of them and not others. --version-override takes the version
+of the dep being checked that it's for, then the override as for --override,
+and only applies to the solve for that version (of any of the deps being
+checked), over all the other overrides:
+
+$ gta --version-override v1.2.0:github.com/foo/baz@^2.0.0 github.com/foo/bar
+
+--ignore takes import paths, or patterns that match them: *, ?, and [...] match
+within one element of a path, as for path.Match, and an element that's ** or
+... matches any number of elements, so github.com/foo/bar/internal/... matches
+that package and all of those below it. Since the solver only takes concrete
+paths, patterns are matched against the project's own packages and their
+imports; packages that are only imported by deps can only be ignored by name.
+
+With --offline, nothing goes to the network: every dep is served from its
+clone in the source cache, as gta warm leaves it, and one that isn't there
+fails rather than being fetched. Versions are the branches and tags the clone
+had when it was last updated. Only git sources can be used, and vanity import
+paths are only recognized if their source sits at the same path:
+
+$ gta warm github.com/foo/bar && gta --offline -r "go test" github.com/foo/bar
+
+In CI, --changed-only lets gta decide for itself whether there's anything to
+do. It reads the import paths that changed (say, in a PR) from stdin, or from
+--changed-file, and only checks the deps that one of them is in. If none are,
+the sweep is skipped, and gta exits 0 - but with output that says it skipped,
+rather than the results of a pass, in every --format. Paths of vendored files
+will do, too, as everything up to the vendor dir is dropped:
+
+$ git diff --name-only origin/master | gta --changed-only -r "go test" github.com/foo/bar
+
+To check many deps, one after another, in a single process, pass --batch and a
+list of them on stdin, one to a line, each with an optional constraint in the
+same form as for --override. Each is checked as if it were the only dep given,
+with the other flags as usual, and as soon as it's done, a line of JSON is
+written for it: the --format json report, plus the dependency and constraint
+it was for, or an "error" if it couldn't be checked at all. A dep with no
+constraint on its line gets whatever the project's manifest says, so the
+constraint flags can't be given along with --batch:
+
+$ printf 'github.com/foo/bar ^1.2.0\ngithub.com/foo/baz branch=master\n' | gta --batch -r "go test"
+
+Defaults for any flags may be set in a .gta.yaml file in the working directory,
+keyed by flag name. Lists set repeatable flags once per element, and anything
+given on the command line takes precedence:
+
+  run:
+  - go build ./...
+  - go test ./...
+  jobs: 4
+  cache-dir: /tmp/gta-cache
+
+gta exits 0 if every version checked was ok, or if --changed-only skipped the
+sweep. If some failed, including any whose tree couldn't be written or whose
+--pre-run failed, the exit status is the number that failed (up to 124).
+It's 125 if constraints and filters left no versions of a dep to check at all,
+and 1 for any other error. With --batch, it's the number of deps that failed,
+or couldn't be checked, counting each line of input once.`,
 	RunE: RunGTA,
 }
 
 var (
-	run                     string
+	overridesFile, color    string
+	sortBy, format, pm      string
+	junit, keepVendor       string
+	summaryJSON             string
+	traceFile, cacheDir     string
+	changedFile, buildTags  string
+	logDir, runDir          string
+	successRE, failRE       string
+	preRun, postRun, via    string
+	gopath, importPath      string
 	branch, semver, version string
-	verbose, trace          bool
+	verbose, trace, shapes  bool
+	quiet, batch            bool
+	showSolution            bool
+	noVendorBackup, noPM    bool
+	forceRestore            bool
+	discardBackup           bool
+	listOnly, summaryOnly   bool
+	noProgress, preferLow   bool
+	includePre, failFast    bool
+	withTest, isolate       bool
+	modules, addDep         bool
+	pinOthers, noStrip      bool
+	lockFloorOn             bool
+	runUnsolved, bisect     bool
+	offline, changedOnly    bool
+	allBranches, dryRun     bool
+	confirm, yes            bool
+	maxAttempts, jobs       int
+	retries                 int
+	maxCombos, maxVersions  int
+	timeout, solveTimeout   time.Duration
+	versions, revisions     []string
+	ignore, excludeVersions []string
+	env, overrides, runs    stringArray
+	versionOverrides        stringArray
 )
 
+// hout is where all human-oriented output is written. It's discarded when a
+// machine-readable output format is requested, so that stdout only contains
+// that format.
+var hout io.Writer = os.Stdout
+
 func main() {
 	// 1. write basic command, absent manifest/lock loading
 	// 2. write support for executing e.g. go test
 	// 3. loader for glide files
-	RootCmd.Flags().StringVarP(&run, "run", "r", "", "Additional command to run (e.g. `go test`) as a check")
+	RootCmd.Flags().VarP(&runs, "run", "r", "Additional command to run (e.g. `go test`) as a check (may be repeated, to run several in turn)")
+	RootCmd.Flags().StringSliceVar(&versions, "versions", nil, "Comma-separated list of exact versions to check")
+	RootCmd.Flags().StringSliceVar(&revisions, "revisions", nil, "Comma-separated list of revisions (commits) to check, e.g. to bisect across raw commits")
+	RootCmd.Flags().BoolVar(&confirm, "confirm", false, "Solve every version first, then list those that solved and ask before running --run against them")
+	RootCmd.Flags().BoolVar(&yes, "yes", false, "With --confirm, list the versions that solved, but run without asking")
+	RootCmd.Flags().BoolVar(&runUnsolved, "run-on-solve-failure", false, "For diagnostics: run --run even for versions that fail to solve, against the lock's tree with the dep at the version being checked (such versions still fail)")
+	RootCmd.Flags().StringVar(&preRun, "pre-run", "", "Command to set things up for --run, run before it for each version; if it fails, the version is an error, and counts as failed")
+	RootCmd.Flags().StringVar(&postRun, "post-run", "", "Command to tear down after --run, run after it for each version, pass or fail; a failure is only warned of")
+	RootCmd.Flags().StringVar(&runDir, "run-dir", "", "Directory within the project in which to run the --run command (default the project root)")
+	RootCmd.Flags().Var(&env, "env", "Extra environment variable for the --run command, as KEY=VALUE (may be repeated)")
+	RootCmd.Flags().StringVar(&successRE, "success-regexp", "", "Regexp that the --run output must also match for a version to pass, as well as every command exiting 0")
+	RootCmd.Flags().StringVar(&failRE, "fail-regexp", "", "Regexp that fails a version if the --run output matches it, even if every command exited 0")
+	RootCmd.Flags().StringVar(&buildTags, "build-tags", "", "Build tags for the --run command, passed to the go tool as -tags in GOFLAGS (see above)")
+	RootCmd.Flags().DurationVar(&timeout, "timeout", 0, "Maximum time to allow the --run command, per version (default no limit)")
+	RootCmd.Flags().DurationVar(&solveTimeout, "solve-timeout", 0, "Maximum time to allow the solver, per version; a version that takes longer fails (default no limit)")
 	RootCmd.Flags().StringVar(&semver, "semver", "", "Semantic version (range or single version) to check")
 	RootCmd.Flags().StringVar(&branch, "branch", "", "Branch to check")
+	RootCmd.Flags().BoolVar(&allBranches, "all-branches", false, "Check every branch (in name order, so --max-versions keeps the first few)")
 	RootCmd.Flags().StringVar(&version, "version", "", "Version (non-semver tag) to check")
+	RootCmd.Flags().IntVarP(&jobs, "jobs", "j", runtime.NumCPU(), "Number of versions to solve (and, with --isolate, run) in parallel")
+	RootCmd.Flags().BoolVar(&noProgress, "no-progress", false, "Don't prefix each version's output with the progress through the sweep")
 	RootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output")
-	RootCmd.Flags().BoolVarP(&trace, "trace", "t", false, "Include solver tracing in output")
+	RootCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Only print the versions that fail, as they do, and a final tally")
+	RootCmd.Flags().BoolVar(&showSolution, "show-solution", false, "Print the version each project resolved to, for each version that solves (implied by --verbose)")
+	RootCmd.Flags().BoolVarP(&trace, "trace", "t", false, "Include solver tracing in output, or on stderr with --format json or tap (implies --jobs 1)")
+	RootCmd.Flags().StringVar(&traceFile, "trace-file", "", "Write solver tracing to the given file, instead of the output (implies --trace)")
+	RootCmd.Flags().BoolVar(&bisect, "bisect", false, "Find the point at which a dep's versions start to fail by binary search, checking about log2(n) of them rather than all n")
+	RootCmd.Flags().BoolVar(&listOnly, "list-only", false, "List the versions that would be checked, then stop without solving")
+	RootCmd.Flags().BoolVar(&shapes, "shapes", false, "Report which versions produce identically-shaped solutions")
+	RootCmd.Flags().StringVar(&pm, "pm", "", "Package manager whose metadata to read: "+pmNames()+", or none (default: detect)")
+	RootCmd.Flags().BoolVar(&noPM, "no-pm", false, "Ignore any package manager metadata files in the project (same as --pm none)")
+	RootCmd.Flags().BoolVar(&withTest, "with-test", true, "Include the project's test dependency constraints in the solve; with --with-test=false, deps only the tests import go unconstrained")
+	RootCmd.Flags().StringSliceVar(&ignore, "ignore", nil, "Import path, or pattern, for the solver to ignore, in addition to those in the project's metadata (may be repeated)")
+	RootCmd.Flags().Var(&overrides, "override", "Override for a project in the depgraph, as root@constraint (may be repeated; see above)")
+	RootCmd.Flags().Var(&versionOverrides, "version-override", "Override that only applies when a dep being checked is at the given version, as version:root@constraint (may be repeated)")
+	RootCmd.Flags().StringVar(&overridesFile, "overrides-file", "", "File from which to read overrides (default: "+strings.Join(overridesFileNames, ", or ")+", if present)")
+	RootCmd.Flags().StringVar(&importPath, "import-root", "", "Import path of the project being checked, if it can't be derived from where it sits on the GOPATH")
+	RootCmd.Flags().StringVar(&gopath, "gopath", "", "GOPATH (which may have several entries) to find the project in, and to give the --run command (default: $GOPATH)")
+	RootCmd.PersistentFlags().StringVar(&cacheDir, "cache-dir", "", "Directory in which to cache sources (default: $GTA_CACHE_DIR, or glide's cache)")
+	RootCmd.Flags().BoolVar(&offline, "offline", false, "Use only sources already in the cache, and fail rather than go to the network for anything (see gta warm)")
+	RootCmd.PersistentFlags().IntVar(&retries, "retries", 0, "Times to retry network operations that fail transiently, with exponential backoff")
+	RootCmd.Flags().BoolVar(&batch, "batch", false, "Read the deps to check from stdin, as one \"pkg [constraint]\" per line, and write one line of JSON for each, as each is checked")
+	RootCmd.Flags().BoolVar(&changedOnly, "changed-only", false, "Read changed import paths from stdin, and only check the deps that any of them are in; if none are, skip the sweep and exit 0")
+	RootCmd.Flags().StringVar(&changedFile, "changed-file", "", "Read the changed import paths for --changed-only from this file, rather than stdin (implies --changed-only)")
+	RootCmd.Flags().BoolVar(&failFast, "fail-fast", false, "Stop at the first version that fails, whether to solve, to be written out, at --pre-run, or in --run")
+	RootCmd.Flags().IntVar(&maxAttempts, "max-attempts", 1, "Maximum attempts per version, shared between solving and running")
+	RootCmd.Flags().StringVar(&color, "color", "auto", "Color the results: auto (only when the output is a terminal), always, or never")
+	RootCmd.Flags().StringVar(&format, "format", "text", "Output format: text, json, or tap")
+	RootCmd.Flags().StringVar(&junit, "junit", "", "Write a JUnit XML report to the given path")
+	RootCmd.Flags().StringVar(&summaryJSON, "summary-json", "", "Write the report that --format json would print to the given path, whatever the --format")
+	RootCmd.Flags().BoolVar(&summaryOnly, "summary-only", false, "Print only the summary table at the end, not the details of each version")
+	RootCmd.Flags().StringVar(&sortBy, "sort-by", "version", "Order of the final report: version, status, or duration")
+	RootCmd.Flags().StringSliceVar(&excludeVersions, "exclude-versions", nil, "Comma-separated list of versions not to check, even if they match")
+	RootCmd.Flags().IntVar(&maxVersions, "max-versions", 0, "Check at most this many of the newest (or, with --prefer-lowest, oldest) matching versions of each dependency (default no limit)")
+	RootCmd.Flags().BoolVar(&includePre, "include-prerelease", false, "Also check prerelease semver versions (e.g. v1.2.0-rc1) that match")
+	RootCmd.Flags().BoolVar(&preferLow, "prefer-lowest", false, "Check versions oldest first, and have the solver prefer the lowest acceptable versions of unlocked deps")
+	RootCmd.Flags().IntVar(&maxCombos, "max-combos", 100, "Maximum number of version combinations to check when checking multiple dependencies")
+	RootCmd.Flags().BoolVar(&isolate, "isolate", false, "Run each version's commands in a temporary copy of the project, leaving its vendor dir alone, so that --jobs applies to running, too")
+	RootCmd.Flags().BoolVar(&lockFloorOn, "lock-as-floor", false, "Only check versions at or above the one each dep is locked to in the project's lock (may be narrowed further with --semver)")
+	RootCmd.Flags().BoolVar(&pinOthers, "pin-others", false, "Pin every dep in the project's lock, other than those being checked, to exactly its locked version")
+	RootCmd.Flags().StringVar(&via, "via", "", "Check the deps given as deps of this one, held at its locked version, or at one given as root@constraint, as for --override")
+	RootCmd.Flags().BoolVar(&addDep, "add", false, "Check deps that the project doesn't use yet, as if it imported the packages given")
+	RootCmd.Flags().BoolVar(&modules, "mod", false, "Experimental: run the --run command in module mode, with a go.mod written for each version's solution, rather than a vendor tree (implies --isolate)")
+	RootCmd.Flags().StringVar(&logDir, "log-dir", "", "Write each version's --run output to a <version>.log file in this directory, whether it passed or not")
+	RootCmd.Flags().BoolVar(&noStrip, "no-strip-vendor", false, "Leave deps' own nested vendor dirs in the trees written for --run, rather than removing them")
+	RootCmd.Flags().StringVar(&keepVendor, "keep-vendor", "", "Keep each version's vendor tree from --run in this directory, as vend-<version>")
+	RootCmd.Flags().BoolVar(&forceRestore, "force-restore", false, "Restore a vendor backup (_origvendor) left by an earlier, interrupted run, replacing the current vendor dir")
+	RootCmd.Flags().BoolVar(&noVendorBackup, "no-vendor-backup", false, "Don't back up the vendor directory to _origvendor, and restore it, when using --run, as for projects without one (vendor must not exist)")
+
+	VersionsCmd.Flags().StringVar(&semver, "semver", "", "Semantic version (range or single version) to list")
+	VersionsCmd.Flags().StringVar(&branch, "branch", "", "Branch to list")
+	VersionsCmd.Flags().StringVar(&version, "version", "", "Version (non-semver tag) to list")
+	VersionsCmd.Flags().BoolVar(&offline, "offline", false, "List the versions in the cache, without going to the network")
+	RootCmd.PersistentPreRunE = applyConfig
+	CleanCmd.Flags().BoolVar(&dryRun, "dry-run", false, "List what would be removed, without removing anything")
+	CleanCmd.Flags().BoolVar(&discardBackup, "discard-backup", false, "Remove the _origvendor backup of the vendor dir, too, discarding what may be the only copy of it")
+	RootCmd.AddCommand(WarmCmd, VersionsCmd, CleanCmd)
+
+	// This version of cobra treats any positional arg given to a root command
+	// that has subcommands as an unknown subcommand. For gta, those args are
+	// the deps to check, so unless a subcommand (or help) was asked for, drop
+	// the subcommands and let RunGTA have them.
+	if c, _, err := RootCmd.Find(os.Args[1:]); err != nil && c == RootCmd && !contains(os.Args[1:], "help") {
+		RootCmd.ResetCommands()
+	}
 
 	if err := RootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
+		switch e := err.(type) {
+		case failedError:
+			os.Exit(e.exitCode())
+		case noMatchError:
+			os.Exit(exitNoMatch)
+		}
 		os.Exit(1)
 	}
 }
 
+// tries returns a parenthetical note on the number of attempts made, if more
+// than one was needed.
+func tries(r sweep.Result) string {
+	if r.Attempts < 2 {
+		return ""
+	}
+	return fmt.Sprintf(" (after %v attempts)", r.Attempts)
+}
+
+// took says how long something took, in verbose mode only.
+func took(d time.Duration) string {
+	if !verbose {
+		return ""
+	}
+	return fmt.Sprintf(" [%s]", d.Round(time.Millisecond))
+}
+
+// sourceCacheDir returns the dir in which sources are cached: --cache-dir, or
+// $GTA_CACHE_DIR, or else glide's cache.
+func sourceCacheDir() string {
+	dir := cacheDir
+	if dir == "" {
+		dir = os.Getenv("GTA_CACHE_DIR")
+	}
+	if dir == "" {
+		dir = filepath.Join(gpath.Home(), "cache")
+	}
+	return dir
+}
+
+// newSourceManager sets up a SourceManager on the cache dir given by
+// sourceCacheDir. Each command sets up exactly one, however many deps it's
+// given, and releases it once it's done; while it exists, gps holds a lock on
+// the cache dir, so a second one on the same dir would fail to set up anyway.
+func newSourceManager() (*gps.SourceMgr, error) {
+	dir := sourceCacheDir()
+	if cacheDir != "" || os.Getenv("GTA_CACHE_DIR") != "" {
+		if err := checkWritable(dir); err != nil {
+			return nil, fmt.Errorf("Cache directory %s is not usable: %s", dir, err)
+		}
+	}
+
+	sm, err := gps.NewSourceManager(dependency.Analyzer{}, dir, false)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to set up SourceManager: %s", err)
+	}
+	return sm, nil
+}
+
 func RunGTA(cmd *cobra.Command, args []string) error {
 	// Turn off errors, now that we're in here
 	cmd.SilenceErrors = true
 	cmd.SilenceUsage = true
+	cmdFlags = cmd.Flags()
 
-	var pkg string
-	switch len(args) {
-	case 1:
-		pkg = args[0]
-		break
+	sm, err := newSourceManager()
+	if err != nil {
+		return err
+	}
+	defer sm.Release()
+
+	if batch {
+		return runBatch(args, sm)
+	}
+	return checkDeps(args, sm, nil)
+}
+
+// checkDeps checks the deps given in args, per the flags, with base as the
+// SourceManager. With --batch, it's called once per line of input, with the
+// same base each time, and the JSON report goes in bl, rather than to stdout.
+func checkDeps(args []string, base gps.SourceManager, bl *batchLine) error {
+	if len(args) == 0 {
+		return fmt.Errorf("You must specify at least one dependency to check against its versions.\n")
+	}
+
+	if quiet && verbose {
+		return fmt.Errorf("--quiet and --verbose can't be used together")
+	}
+
+	switch format {
+	case "text":
+	case "json", "tap":
+		hout = ioutil.Discard
 	default:
-		return fmt.Errorf("You must specify a single dependency to check against its versions.\n")
+		return fmt.Errorf("%q is not a valid value for --format; must be one of text, json, or tap", format)
+	}
+
+	switch color {
+	case "auto":
+		colorize = format == "text" && isTerminal(os.Stdout)
+	case "always":
+		colorize = format == "text"
+	case "never":
+	default:
+		return fmt.Errorf("%q is not a valid value for --color; must be one of auto, always, or never", color)
+	}
+
+	switch sortBy {
+	case "version", "status", "duration":
+	default:
+		return fmt.Errorf("%q is not a valid value for --sort-by; must be one of version, status, or duration", sortBy)
+	}
+
+	switch {
+	case pm == "none":
+		noPM = true
+	case pm != "" && findLoader(pm) == nil:
+		return fmt.Errorf("%q is not a valid value for --pm; must be one of %s, or none", pm, pmNames())
+	case noPM && pm != "":
+		return fmt.Errorf("--no-pm and --pm are mutually exclusive")
+	}
+
+	if maxAttempts < 1 {
+		return fmt.Errorf("--max-attempts must be at least 1")
+	}
+
+	for _, e := range env {
+		if strings.Index(e, "=") < 1 {
+			return fmt.Errorf("--env %q is not of the form KEY=VALUE", e)
+		}
+	}
+
+	if retries < 0 {
+		return fmt.Errorf("--retries must not be negative")
+	}
+
+	if maxVersions < 0 {
+		return fmt.Errorf("--max-versions must not be negative")
+	}
+
+	for _, ig := range ignore {
+		if err := checkIgnorePattern(ig); err != nil {
+			return err
+		}
+	}
+
+	if modules {
+		if len(runs) == 0 {
+			return fmt.Errorf("--mod only makes sense with --run")
+		}
+		isolate = true
 	}
 
 	wd, err := os.Getwd()
@@ -82,275 +561,1316 @@ func RunGTA(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("Could not get working directory: %s", err)
 	}
 
-	an := dependency.Analyzer{}
-	sm, err := gps.NewSourceManager(an, filepath.Join(gpath.Home(), "cache"), false)
-	if err != nil {
-		return fmt.Errorf("Failed to set up SourceManager: %s", err)
+	var changed []string
+	if changedFile != "" {
+		changedOnly = true
+	}
+	if changedOnly {
+		var r io.Reader = os.Stdin
+		if changedFile != "" {
+			f, err := os.Open(changedFile)
+			if err != nil {
+				return fmt.Errorf("Could not open --changed-file %s: %s", changedFile, err)
+			}
+			defer f.Close()
+			r = f
+		} else if isTerminal(os.Stdin) {
+			return fmt.Errorf("--changed-only reads the changed paths from stdin, which is a terminal; pipe them in, or name a file with --changed-file")
+		}
+		if changed, err = readChanged(r); err != nil {
+			return fmt.Errorf("Could not read the changed paths: %s", err)
+		}
 	}
-	defer sm.Release()
 
-	root, err := sm.DeduceProjectRoot(pkg)
-	if err != nil {
-		return fmt.Errorf("Could not detect source info for %s: %s", pkg, err)
+	// Assume the current directory is correctly placed on a GOPATH, and derive
+	// the ProjectRoot from it
+	gp, renv := runGOPATH()
+	importroot := importPath
+	if importroot == "" {
+		if importroot, err = importRoot(wd, gp); err != nil {
+			return err
+		}
+	} else if path.IsAbs(importroot) || path.Clean(importroot) != importroot || strings.Contains(importroot, "\\") {
+		return fmt.Errorf("--import-root %q is not a valid import path", importroot)
 	}
 
-	pi := gps.ProjectIdentifier{
-		ProjectRoot: root,
+	// Catch the case of being run from the wrong directory up front, rather
+	// than letting it fail cryptically, deep in the solver
+	if importroot == "" || !isGoProject(wd) {
+		return fmt.Errorf("%s does not appear to be the root of a Go project (no Go source files found); gta must be run from the root of your project", wd)
 	}
-	vlist, err := sm.ListVersions(pi)
+
+	// A backup left behind by an earlier run that was killed before it could
+	// clean up is probably the user's real vendor dir, so never clobber it
+	ovpath := filepath.Join(wd, "_origvendor")
+	if _, err = os.Stat(ovpath); err == nil {
+		switch {
+		case forceRestore:
+			if err = restoreVendor(wd); err != nil {
+				return fmt.Errorf("Could not restore %s: %s", ovpath, err)
+			}
+			fmt.Fprintf(hout, "Restored vendor dir from %s\n", ovpath)
+		case noVendorBackup:
+			// It's left alone, as nothing is backed up there
+		case len(runs) > 0 && !isolate:
+			return fmt.Errorf("%s already exists, probably left behind by an earlier gta run that was interrupted; it may contain your original vendor dir. Move it back to vendor yourself, or pass --force-restore to have gta do so (discarding the current vendor dir)", ovpath)
+		}
+	}
+
+	// --no-vendor-backup skips the backup to _origvendor, and the restore,
+	// entirely; but if there IS a vendor dir, bail out now, rather than
+	// clobbering it later.
+	vpath := filepath.Join(wd, "vendor")
+	if _, err = os.Stat(vpath); err == nil && len(runs) > 0 && !isolate && noVendorBackup {
+		return fmt.Errorf("--no-vendor-backup was specified, but %s exists and would be overwritten", vpath)
+	}
+
+	if bisect && confirm {
+		return fmt.Errorf("--bisect and --confirm can't be used together, as each version is run as soon as it's solved")
+	}
+
+	// Patterns for output that --run's exit statuses can't be trusted about
+	successPat, err := runPattern("--success-regexp", successRE)
+	if err != nil {
+		return err
+	}
+	failPat, err := runPattern("--fail-regexp", failRE)
 	if err != nil {
-		return fmt.Errorf("Could not retrieve version list for %s: %s", pi, err)
+		return err
 	}
 
-	if len(vlist) == 0 {
-		// shouldn't be possible, but whatever
-		return fmt.Errorf("No versions could be located for %s", pi)
+	if buildTags != "" {
+		if len(runs) == 0 {
+			return fmt.Errorf("--build-tags only makes sense with --run")
+		}
+		renv = append(renv, "GOFLAGS="+withBuildTags(renv, buildTags))
 	}
 
-	gps.SortForUpgrade(vlist)
+	if runUnsolved && len(runs) == 0 {
+		return fmt.Errorf("--run-on-solve-failure only makes sense with --run")
+	}
 
-	// obnoxious constraint parsing
-	var c gps.Constraint
-	switch {
-	case branch == "" && semver == "" && version == "":
-		c = gps.Any()
-	case branch != "":
-		if semver != "" || version != "" {
-			return fmt.Errorf("Please specify only one type of constraint - branch, version, or semver")
+	if logDir != "" {
+		if len(runs) == 0 {
+			return fmt.Errorf("--log-dir only makes sense with --run")
 		}
-		c = gps.NewBranch(branch)
-	case version != "":
-		if semver != "" || branch != "" {
-			return fmt.Errorf("Please specify only one type of constraint - branch, version, or semver")
+		if err = checkWritable(logDir); err != nil {
+			return fmt.Errorf("Log directory %s is not usable: %s", logDir, err)
 		}
-		c = gps.NewVersion(version)
-	case semver != "":
-		if version != "" || branch != "" {
-			return fmt.Errorf("Please specify only one type of constraint - branch, version, or semver")
+	}
+
+	// The run dir is given to the sweep relative to the project root, so that
+	// it can be found in the copies made by --isolate, too
+	var rundir string
+	if runDir != "" {
+		if len(runs) == 0 {
+			return fmt.Errorf("--run-dir only makes sense with --run")
 		}
-		c, err = gps.NewSemverConstraint(semver)
+		abs, err := filepath.Abs(runDir)
 		if err != nil {
-			return fmt.Errorf("%s is not a valid semver constraint", semver)
+			return fmt.Errorf("Could not resolve --run-dir %s: %s", runDir, err)
+		}
+		if fi, err := os.Stat(abs); err != nil || !fi.IsDir() {
+			return fmt.Errorf("--run-dir %s is not a directory", runDir)
+		}
+		rundir, err = filepath.Rel(wd, abs)
+		if err != nil || rundir == ".." || strings.HasPrefix(rundir, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("--run-dir %s is not within the project at %s", runDir, wd)
 		}
 	}
 
-	// Assume the current directory is correctly placed on a GOPATH, and derive
-	// the ProjectRoot from it
-	srcprefix := filepath.Join(build.Default.GOPATH, "src") + string(filepath.Separator)
-	importroot := filepath.ToSlash(strings.TrimPrefix(wd, srcprefix))
+	// Each command is split up front, unless it's a template; those can only
+	// be split once they've been expanded, per version.
+	cmds := make([][]string, len(runs))
+	tmpls := make([]*template.Template, len(runs))
+	var templated bool
+	for k, rs := range runs {
+		if strings.Contains(rs, "{{") {
+			tmpls[k], err = template.New("run").Parse(rs)
+			if err != nil {
+				return fmt.Errorf("Could not parse --run template %q: %s", rs, err)
+			}
+			templated = true
+			continue
+		}
 
-	// Use the analyzer to figure out this project, too
-	m, l, err := an.DeriveManifestAndLock(wd, gps.ProjectRoot(importroot))
+		cmds[k], err = splitCommand(rs)
+		if err != nil {
+			return fmt.Errorf("Could not parse --run command %q: %s", rs, err)
+		}
+		if len(cmds[k]) == 0 {
+			return fmt.Errorf("--run command was empty")
+		}
+	}
+
+	// The hooks around --run are split up front, too, as they're the same
+	// for every version
+	var hooks [2][]string
+	for k, h := range []struct{ flag, s string }{{"--pre-run", preRun}, {"--post-run", postRun}} {
+		if h.s == "" {
+			continue
+		}
+		if len(runs) == 0 {
+			return fmt.Errorf("%s only makes sense with --run", h.flag)
+		}
+		if hooks[k], err = splitCommand(h.s); err != nil {
+			return fmt.Errorf("Could not parse %s command %q: %s", h.flag, h.s, err)
+		}
+		if len(hooks[k]) == 0 {
+			return fmt.Errorf("%s command was empty", h.flag)
+		}
+	}
+
+	c, err := parseConstraint(branch, version, semver)
 	if err != nil {
-		return fmt.Errorf("Error on trying to read project manifest and lock: %s", err)
+		return err
+	}
+	var nsel int
+	for _, sel := range []bool{!gps.IsAny(c), len(versions) > 0, len(revisions) > 0, allBranches} {
+		if sel {
+			nsel++
+		}
+	}
+	if nsel > 1 {
+		return errOneConstraint
+	}
+	if lockFloorOn && (branch != "" || version != "" || len(versions) > 0 || len(revisions) > 0 || allBranches) {
+		return fmt.Errorf("--lock-as-floor can only be combined with --semver, not other constraints")
+	}
+
+	sm := base
+
+	// Deps given as local repositories are served from those, rather than
+	// from their upstream sources
+	locals := make(map[gps.ProjectRoot]string)
+	// The argument each target was given as, for the repro lines of failures
+	given := make(map[gps.ProjectRoot]string)
+	for k, pkg := range args {
+		root, dir, err := localDep(pkg, gp)
+		if err != nil {
+			return err
+		}
+		if dir != "" {
+			locals[root] = dir
+			given[root] = pkg
+			args[k] = string(root)
+		}
+	}
+	if len(locals) > 0 || offline {
+		lsm := newLocalSourceManager(base, dependency.Analyzer{}, locals)
+		if offline {
+			lsm.offline = sourceCacheDir()
+		}
+		defer lsm.release()
+		sm = lsm
 	}
-	rm := prepManifest(m)
 
-	//pretty.Println(m, rm, l)
+	// Read this project's metadata, too, unless we've been told not to. This
+	// is done once, and the result reused for every version (and dependency)
+	// that's checked.
+	var m gps.Manifest
+	var l gps.Lock
+	if !noPM {
+		m, l, err = loadMetadata(pm, wd, gps.ProjectRoot(importroot))
+		if err != nil {
+			return fmt.Errorf("Error on trying to read project manifest and lock: %s", err)
+		}
+	}
+
+	if pinOthers && l == nil {
+		return fmt.Errorf("--pin-others needs a lock file to pin to, but none was found")
+	}
+	if lockFloorOn && l == nil {
+		return fmt.Errorf("--lock-as-floor needs a lock file to start from, but none was found")
+	}
 
-	var focus gps.ProjectConstraint
-	var has bool
-	if focus, has = rm.c[root]; !has {
-		focus = gps.ProjectConstraint{
-			Ident: gps.ProjectIdentifier{
-				ProjectRoot: root,
-			},
+	fovr, err := readOverrides(wd, overridesFile)
+	if err != nil {
+		return err
+	}
+	for _, o := range overrides {
+		root, pp, err := parseOverride(o)
+		if err != nil {
+			return err
 		}
+		if fovr == nil {
+			fovr = make(gps.ProjectConstraints)
+		}
+		fovr[root] = pp
 	}
 
-	focus.Constraint = c
+	var vovr map[string]gps.ProjectConstraints
+	for _, o := range versionOverrides {
+		v, root, pp, err := parseVersionOverride(o)
+		if err != nil {
+			return err
+		}
+		if vovr == nil {
+			vovr = make(map[string]gps.ProjectConstraints)
+		}
+		if vovr[v] == nil {
+			vovr[v] = make(gps.ProjectConstraints)
+		}
+		vovr[v][root] = pp
+	}
 
-	// Set up params, including tracing
-	params := gps.SolveParameters{
-		Manifest:   rm,
-		Lock:       l,
-		RootDir:    wd,
-		ImportRoot: gps.ProjectRoot(importroot),
+	// What the project's manifest says about each dep, if anything
+	mc := make(map[gps.ProjectRoot]gps.Constraint)
+	// Everything the project is known to depend on, one way or another
+	known := make(map[gps.ProjectRoot]bool)
+	if m != nil {
+		for _, d := range m.DependencyConstraints() {
+			mc[d.Ident.ProjectRoot] = d.Constraint
+			known[d.Ident.ProjectRoot] = true
+		}
+		for _, d := range m.TestDependencyConstraints() {
+			known[d.Ident.ProjectRoot] = true
+		}
+	}
+	if l != nil {
+		for _, p := range l.Projects() {
+			known[p.Ident().ProjectRoot] = true
+		}
 	}
 
-	if trace {
-		params.Trace = true
-		params.TraceLogger = log.New(os.Stdout, "", 0)
+	// With --via, the targets are deps of the one named, which is held at
+	// one version for the whole sweep, with an override
+	var viaID gps.ProjectIdentifier
+	var viaAt gps.Constraint
+	if via != "" {
+		if addDep {
+			return fmt.Errorf("--via and --add can't be used together")
+		}
+		viaRoot, at, err := parseVia(via, l)
+		if err != nil {
+			return err
+		}
+		viaAt = at
+		if fovr == nil {
+			fovr = make(gps.ProjectConstraints)
+		}
+		if pp, has := fovr[viaRoot]; has && pp.Constraint != nil {
+			return fmt.Errorf("--via %s would hold it at %s, but it's already overridden to %s", viaRoot, viaAt, pp.Constraint)
+		}
+		viaID = sourceIdent(viaRoot, m, fovr)
+		fovr[viaRoot] = gps.ProjectProperties{
+			NetworkName: viaID.NetworkName,
+			Constraint:  viaAt,
+		}
+		if !known[viaRoot] {
+			fmt.Fprintf(os.Stderr, "Warning: %s does not appear to depend on %s, so there may be nothing to check through it\n", importroot, viaRoot)
+		}
 	}
 
-	var vl []gps.Version
-	for _, v := range vlist {
-		if focus.Constraint.Matches(v) {
-			vl = append(vl, v)
+	var imps map[string]bool
+	// Packages of targets that the project doesn't import yet, with --add
+	var add []string
+
+	// The solver only takes concrete import paths, so patterns can only be
+	// resolved against what's visible from here: the project's own packages,
+	// and everything they import
+	ig := ignore
+	for _, s := range ignore {
+		if !isIgnorePattern(s) {
+			continue
 		}
+		imps = projectImports(wd)
+		pkgs := projectPackages(wd, importroot)
+		for imp := range imps {
+			pkgs[imp] = true
+		}
+		var unmatched []string
+		ig, unmatched = expandIgnores(ignore, pkgs)
+		for _, u := range unmatched {
+			fmt.Fprintf(os.Stderr, "Warning: --ignore %s matched no packages in or imported by %s\n", u, importroot)
+		}
+		break
 	}
 
-	if len(vl) == 0 {
-		return fmt.Errorf("%s has %v versions, but none matched constraint %s", root, len(vlist), c)
+	// Every target's source is checked for up front, so that one that can't be
+	// reached fails the run straight away, and clearly, rather than after the
+	// others' versions have been gone through
+	type targetArg struct {
+		pkg  string
+		root gps.ProjectRoot
 	}
+	var targs []targetArg
+	// Targets that --changed-only leaves out
+	var unchanged []gps.ProjectRoot
+	// Each target's identifier, with the source it's to come from, so that
+	// a fork is visibly one wherever a target is named
+	ids := make(map[gps.ProjectRoot]gps.ProjectIdentifier)
+	seen := make(map[gps.ProjectRoot]bool)
+	for _, pkg := range args {
+		var root gps.ProjectRoot
+		err := sweep.Retry(retries, func() (err error) {
+			root, err = sm.DeduceProjectRoot(pkg)
+			return
+		})
+		if err != nil {
+			return fmt.Errorf("Could not detect source info for %s: %s", pkg, err)
+		}
+		// Multiple packages from the same project are the same target
+		if seen[root] {
+			continue
+		}
+		seen[root] = true
+		id := sourceIdent(root, m, fovr)
+		ids[root] = id
+		if given[root] == "" {
+			given[root] = pkg
+		}
 
-	fmt.Printf("Checking %s with the following versions:\n\t%s\n", root, vl)
+		if changedOnly && !touches(changed, root) {
+			unchanged = append(unchanged, root)
+			continue
+		}
 
-	type solnOrErr struct {
-		v   gps.Version
-		s   gps.Solution
-		err error
+		if locals[root] == "" {
+			err = sweep.Retry(retries, func() error {
+				ok, err := sm.SourceExists(id)
+				if err == nil && !ok {
+					err = fmt.Errorf("it's neither in the cache nor reachable upstream")
+				}
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("Cannot reach source for %s: %s", ppi(id), err)
+			}
+		}
+		targs = append(targs, targetArg{pkg: pkg, root: root})
 	}
 
-	ppi := func(id gps.ProjectIdentifier) string {
-		if id.NetworkName == "" || id.NetworkName == string(id.ProjectRoot) {
-			return string(id.ProjectRoot)
+	if len(targs) == 0 {
+		names := make([]string, len(unchanged))
+		for k, root := range unchanged {
+			names[k] = string(root)
+		}
+		reason := fmt.Sprintf("none of the changed paths are in %s", strings.Join(names, " or "))
+
+		switch {
+		case bl != nil:
+			bl.jsonReport = skippedJSONReport(unchanged, reason)
+		case format == "json":
+			err = writeSkippedJSON(os.Stdout, unchanged, reason)
+		case format == "tap":
+			err = writeSkippedTAP(os.Stdout, reason)
+		default:
+			fmt.Fprintf(hout, "Skipped: %s, so there's nothing to check.\n", reason)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to write %s output: %s", strings.ToUpper(format), err)
+		}
+		if junit != "" {
+			if err = writeSkippedJUnit(junit, unchanged, reason); err != nil {
+				return fmt.Errorf("Failed to write JUnit report: %s", err)
+			}
 		}
-		return fmt.Sprintf("%s (from %s)", id.ProjectRoot, id.NetworkName)
+		if summaryJSON != "" {
+			if err = writeJSONFile(summaryJSON, skippedJSONReport(unchanged, reason)); err != nil {
+				return fmt.Errorf("Failed to write JSON summary: %s", err)
+			}
+		}
+		return nil
+	}
+	for _, root := range unchanged {
+		fmt.Fprintf(hout, "Skipping %s, as none of the changed paths are in it\n", ppi(ids[root]))
 	}
 
-	solns := make([]solnOrErr, len(vl))
-	for k, v := range vl {
-		fmt.Printf("Looking for solution with %s@%s...", root, v)
-		focus.Constraint = v
-		rm.c[root] = focus
+	var targets []sweep.Target
+	for _, ta := range targs {
+		pkg, root := ta.pkg, ta.root
+
+		// Checking a dep the project doesn't use is a way of trying out a new
+		// one, which --add makes explicit; without it, it's more often the
+		// wrong directory or a typo. With --via, it's the intermediate dep
+		// that ought to use it.
+		if via != "" {
+			if !declares(sm, viaID, viaAt, root) {
+				fmt.Fprintf(os.Stderr, "Warning: %s at %s doesn't list %s among its deps; checking it anyway, but it may not be in the solutions at all\n", ppi(viaID), viaAt, root)
+			}
+		} else if addDep || !known[root] {
+			if imps == nil {
+				imps = projectImports(wd)
+			}
+			var found bool
+			for imp := range imps {
+				if imp == string(root) || strings.HasPrefix(imp, string(root)+"/") {
+					found = true
+					break
+				}
+			}
+			switch {
+			case !found && addDep:
+				add = append(add, pkg)
+			case !found:
+				fmt.Fprintf(os.Stderr, "Warning: %s does not appear to depend on %s; checking it anyway (pass --add if you mean to try adding it)\n", importroot, root)
+			}
+		}
 
-		// TODO parallel, bwahaha
-		soe := solnOrErr{v: v}
-		// TODO reparse root project every time...horribly wasteful
-		var s gps.Solver
-		s, soe.err = gps.Prepare(params, sm)
-		if soe.err == nil {
-			soe.s, soe.err = s.Solve()
+		pi := ids[root]
+		var vlist []gps.Version
+		err = sweep.Retry(retries, func() (err error) {
+			vlist, err = sm.ListVersions(pi)
+			return
+		})
+		if err != nil {
+			return fmt.Errorf("Could not retrieve version list for %s: %s", ppi(pi), err)
 		}
 
-		if soe.err == nil {
-			fmt.Println("success!")
-			if verbose {
-				for _, p := range soe.s.Projects() {
+		if len(vlist) == 0 {
+			// shouldn't be possible, but whatever
+			return fmt.Errorf("No versions could be located for %s", ppi(pi))
+		}
+
+		if preferLow {
+			gps.SortForDowngrade(vlist)
+		} else {
+			gps.SortForUpgrade(vlist)
+		}
+		vlist = sweep.UniqueVersions(vlist)
+
+		// If no constraint was given explicitly, fall back on whatever the
+		// project's manifest says about the dep
+		tc := c
+		if mcc := mc[root]; gps.IsAny(c) && mcc != nil && !lockFloorOn {
+			tc = mcc
+		}
+		if lockFloorOn {
+			floor, lv, err := lockFloor(l, root, vlist)
+			if err != nil {
+				return err
+			}
+			tc = floor
+			if !gps.IsAny(c) {
+				tc = c.Intersect(floor)
+			}
+			fmt.Fprintf(hout, "%s is locked to %s; checking the versions from there up\n", root, lv)
+		}
+
+		var vl []gps.Version
+		if len(versions) > 0 {
+			vl, err = pickVersions(vlist, versions)
+			if err != nil {
+				return fmt.Errorf("%s: %s", root, err)
+			}
+		} else if len(revisions) > 0 {
+			vl, err = pickRevisions(sm, pi, vlist, revisions)
+			if err != nil {
+				return fmt.Errorf("%s: %s", root, err)
+			}
+		} else if allBranches {
+			for _, v := range vlist {
+				if v.Type() == "branch" {
+					vl = append(vl, v)
+				}
+			}
+			if len(vl) == 0 {
+				return noMatchError(fmt.Sprintf("%s has %v versions, but no branches", root, len(vlist)))
+			}
+		} else {
+			var npre int
+			for _, v := range vlist {
+				if !tc.Matches(v) {
+					continue
+				}
+				if !includePre && isPrerelease(v) {
+					npre++
+					continue
+				}
+				vl = append(vl, v)
+			}
+			if npre > 0 {
+				fmt.Fprintf(hout, "Excluded %v prerelease version(s) of %s; use --include-prerelease to check them\n", npre, root)
+			}
+		}
+
+		if len(excludeVersions) > 0 {
+			var kept, excluded []gps.Version
+			for _, v := range vl {
+				if contains(excludeVersions, v.String()) {
+					excluded = append(excluded, v)
+				} else {
+					kept = append(kept, v)
+				}
+			}
+			if len(excluded) > 0 {
+				fmt.Fprintf(hout, "Excluded %v version(s) of %s, per --exclude-versions: %s\n", len(excluded), root, excluded)
+			}
+			if len(kept) == 0 && len(vl) > 0 {
+				return noMatchError(fmt.Sprintf("All %v versions of %s that matched constraint %s were excluded by --exclude-versions", len(vl), root, tc))
+			}
+			vl = kept
+		}
+
+		if len(vl) == 0 {
+			return noMatchError(fmt.Sprintf("%s has %v versions, but none matched constraint %s; the newest are %s", root, len(vlist), tc, strings.Join(newest(vlist, 3), ", ")))
+		}
+
+		// The list is in upgrade (or downgrade) order, so truncating keeps the
+		// newest (or oldest)
+		if maxVersions > 0 && len(vl) > maxVersions {
+			which := "newest"
+			switch {
+			case allBranches:
+				// Branches sort by name, which says nothing about age
+				which = "first"
+			case preferLow:
+				which = "oldest"
+			}
+			fmt.Fprintf(hout, "Only checking the %s %v of the %v matching versions of %s, per --max-versions\n", which, maxVersions, len(vl), root)
+			vl = vl[:maxVersions]
+		}
+
+		if listOnly {
+			fmt.Fprintf(hout, "%s has %v versions:\n\t%s\n", root, len(vlist), vlist)
+		}
+		targets = append(targets, sweep.Target{Root: root, Versions: vl, Transitive: via != ""})
+	}
+
+	if listOnly {
+		for _, t := range targets {
+			fmt.Fprintf(hout, "Would check %s with the following versions:\n\t%s\n", ppi(ids[t.Root]), t.Versions)
+		}
+		return nil
+	}
+
+	for v := range vovr {
+		var found bool
+		for _, t := range targets {
+			for _, tv := range t.Versions {
+				found = found || tv.String() == v
+			}
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "Warning: --version-override is for %s, which is not among the versions to be checked\n", v)
+		}
+	}
+
+	if bisect && len(targets) > 1 {
+		return fmt.Errorf("--bisect can only be used with one dependency at a time")
+	}
+
+	ncombos := countCombos(targets)
+	if ncombos > maxCombos {
+		return fmt.Errorf("Checking all version combinations of the %v dependencies would require %v solves, but --max-combos is %v; narrow the constraints or raise --max-combos", len(targets), ncombos, maxCombos)
+	}
+
+	// With --quiet, only what went wrong is of interest
+	chatter := hout
+	if quiet {
+		chatter = ioutil.Discard
+	}
+
+	for _, t := range targets {
+		fmt.Fprintf(chatter, "Checking %s with the following versions:\n\t%s\n", ppi(ids[t.Root]), t.Versions)
+	}
+	if via != "" {
+		fmt.Fprintf(chatter, "(as deps of %s, held at %s)\n", ppi(viaID), viaAt)
+	}
+	if len(targets) > 1 {
+		fmt.Fprintf(chatter, "That's %v combinations in total.\n", ncombos)
+	}
+
+	// Progress is only of interest to someone watching, and can't be told
+	// when bisecting, as the number of checks isn't known up front
+	showProgress := !noProgress && !bisect && isTerminal(os.Stdout)
+	progress := func(k, n int) string {
+		if !showProgress {
+			return ""
+		}
+		return fmt.Sprintf("[%v/%v] ", k, n)
+	}
+
+	var nsolved, nsolns, nran int
+	// The most recent result, if it solved; in verbose mode, a failure right
+	// after a success is compared against it
+	var lastSolved *sweep.Result
+	opts := sweep.Options{
+		RootDir:           wd,
+		ImportRoot:        gps.ProjectRoot(importroot),
+		Manifest:          m,
+		Lock:              l,
+		Overrides:         fovr,
+		VersionOverrides:  vovr,
+		Ignore:            ig,
+		Add:               add,
+		Downgrade:         preferLow,
+		PinLock:           pinOthers,
+		NoTestConstraints: !withTest,
+		SourceManager:     sm,
+		Targets:           targets,
+		Run:               cmds,
+		PreRun:            hooks[0],
+		PostRun:           hooks[1],
+		Jobs:              jobs,
+		MaxAttempts:       maxAttempts,
+		Retries:           retries,
+		FailFast:          failFast,
+		SolveTimeout:      solveTimeout,
+		Timeout:           timeout,
+		SuccessPattern:    successPat,
+		FailPattern:       failPat,
+		Isolate:           isolate,
+		Modules:           modules,
+		RunDir:            rundir,
+		KeepVendor:        keepVendor,
+		RunOnSolveFailure: runUnsolved,
+		NoStripVendor:     noStrip,
+		NoVendorBackup:    noVendorBackup,
+		Env:               renv,
+		OnSolve: func(r sweep.Result) {
+			nsolved++
+			w := hout
+			if r.SolveErr == nil {
+				w = chatter
+			}
+			fmt.Fprintf(w, "%sLooking for solution with %s...", progress(nsolved, ncombos), ppc(r.Combo, ids))
+			if r.SolveErr != nil {
+				fmt.Fprintf(w, "%s%s.%s\n", paint(red, "failed"), tries(r), took(r.SolveDuration))
+				if r.Fallback != nil {
+					// It's still to be run, so counts towards the runs' progress
+					nsolns++
+				}
+				if verbose {
+					fmt.Fprintln(w, r.SolveErr)
+					printConflicts(r.SolveErr)
+					if lastSolved != nil {
+						printFailureDiff(sm, *lastSolved, r, ids)
+					}
+				}
+				lastSolved = nil
+				return
+			}
+
+			nsolns++
+			lastSolved = &r
+			fmt.Fprintf(w, "%s%s%s\n", paint(green, "success!"), tries(r), took(r.SolveDuration))
+			if verbose || showSolution {
+				for _, p := range r.Solution.Projects() {
 					id := p.Ident()
 					switch v := p.Version().(type) {
 					case gps.Revision:
-						fmt.Printf("\t%s at %s\n", ppi(id), v.String()[:7])
+						fmt.Fprintf(w, "\t%s at %s\n", ppi(id), shortRev(revVCS(id, locals), v.String()))
 					case gps.UnpairedVersion:
-						fmt.Printf("\t%s at %s\n", ppi(id), v)
+						fmt.Fprintf(w, "\t%s at %s\n", ppi(id), v)
 					case gps.PairedVersion:
-						fmt.Printf("\t%s at %s (%s)\n", ppi(id), v, v.Underlying().String()[:7])
+						fmt.Fprintf(w, "\t%s at %s (%s)\n", ppi(id), v, shortRev(revVCS(id, locals), v.Underlying().String()))
 					}
 				}
 			}
-		} else {
-			fmt.Println("failed.")
-			if verbose {
-				fmt.Println(soe.err)
+		},
+		OnRun: func(r sweep.Result) {
+			w := hout
+			if r.RunErr == nil && r.WriteErr == nil && r.PreRunErr() == nil {
+				w = chatter
+			}
+			if nran == 0 {
+				fmt.Fprintln(w, "") // just a spacer
+			}
+			nran++
+			reused := ""
+			if r.Reused {
+				reused = " (reusing tree)"
+			}
+			if r.Fallback != nil {
+				reused += " " + paint(yellow, "(despite no solution, against the locked tree)")
+			}
+			fmt.Fprintf(w, "%sRunning `%s` against %s%s...", progress(nran, nsolns), strings.Join(runs, "`, then `"), ppc(r.Combo, ids), reused)
+			switch {
+			case r.WriteErr != nil:
+				fmt.Fprintf(w, "%s.\n", paint(yellow, "skipped"))
+			case r.PreRunErr() != nil:
+				fmt.Fprintf(w, "%s, as `%s` failed.\n", paint(yellow, "skipped"), preRun)
+			case r.RunErr != nil && len(runs) > 1:
+				fmt.Fprintf(w, "%s at `%s`.%s\n", paint(red, "failed"), failedRun(runs, r), took(r.RunDuration))
+			case r.RunErr != nil:
+				fmt.Fprintf(w, "%s.%s\n", paint(red, "failed"), took(r.RunDuration))
+			default:
+				fmt.Fprintf(w, "%s.%s\n", paint(green, "ok"), took(r.RunDuration))
+			}
+			if err := r.PostRunErr(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: --post-run `%s` for %s failed with %s, output:\n%s\n", postRun, ppc(r.Combo, ids), err, string(r.PostRun.Output))
 			}
+			if r.KeepErr != nil {
+				fmt.Fprintf(os.Stderr, "Warning: the vendor tree for %s could not be kept in --keep-vendor %s: %s\n", ppc(r.Combo, ids), keepVendor, r.KeepErr)
+			}
+		},
+	}
+	noun := "versions"
+	if len(targets) > 1 {
+		noun = "combinations"
+	}
+	var declined bool
+	if confirm && len(runs) > 0 {
+		opts.BeforeRun = func(results []sweep.Result) bool {
+			var ok, unsolved []sweep.Combo
+			for _, r := range results {
+				switch {
+				case r.SolveErr == nil:
+					ok = append(ok, r.Combo)
+				case r.Fallback != nil:
+					unsolved = append(unsolved, r.Combo)
+				}
+			}
+			if len(ok)+len(unsolved) == 0 {
+				return false
+			}
+
+			fmt.Fprintf(hout, "\n%v of the %v %s solved:\n", len(ok), len(results), noun)
+			printCombos(ok)
+			if len(unsolved) > 0 {
+				fmt.Fprintf(hout, "and %v didn't, which --run-on-solve-failure will run against the locked tree:\n", len(unsolved))
+				printCombos(unsolved)
+			}
+			if yes {
+				return true
+			}
+
+			fmt.Fprintf(os.Stderr, "Run `%s` against them? [y/N] ", strings.Join(runs, "`, then `"))
+			line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			switch strings.ToLower(strings.TrimSpace(line)) {
+			case "y", "yes":
+				return true
+			}
+			declined = true
+			return false
 		}
-		solns[k] = soe
 	}
-	fmt.Println("") // just a spacer
 
-	// If we have to create these vendor trees, then back up the original vendor
-	vpath := filepath.Join(wd, "vendor")
-	fails := make(map[gps.Version]bool)
-	if run != "" {
-		if _, err = os.Stat(vpath); err == nil {
-			err = os.Rename(vpath, filepath.Join(wd, "_origvendor"))
-			if err != nil {
-				return fmt.Errorf("Failed to back up vendor folder: %s", err)
+	if templated {
+		opts.RunFor = func(c sweep.Combo) ([][]string, error) {
+			vcmds := make([][]string, len(cmds))
+			for k, tmpl := range tmpls {
+				if tmpl == nil {
+					vcmds[k] = cmds[k]
+					continue
+				}
+
+				var err error
+				if vcmds[k], err = expandCommand(tmpl, c); err != nil {
+					return nil, fmt.Errorf("could not expand --run template %q: %s", runs[k], err)
+				}
 			}
-			defer os.Rename(filepath.Join(wd, "_origvendor"), vpath)
+			return vcmds, nil
 		}
 	}
 
-	for _, soln := range solns {
-		nv := fmt.Sprintf("%s@%s", root, soln.v)
-		// If solving failed, no point in even checking the run
-		if soln.err != nil {
-			fails[soln.v] = true
-			fmt.Printf("%s failed solving: %s\n", nv, soln.err)
-			continue
+	if traceFile != "" {
+		tf, err := os.Create(traceFile)
+		if err != nil {
+			return fmt.Errorf("Could not create trace file: %s", err)
 		}
+		defer tf.Close()
+		opts.TraceLogger = log.New(tf, "", 0)
+	} else if trace {
+		// A machine-readable format leaves no human output for the trace to
+		// go in, and it mustn't be mixed into stdout
+		tw := hout
+		if format != "text" {
+			tw = os.Stderr
+		}
+		opts.TraceLogger = log.New(tw, "", 0)
+	}
 
-		if run == "" {
-			fmt.Printf("%s succeeded\n", nv)
-		} else {
-			err = gps.WriteDepTree(vpath, soln.s, sm, true)
-			if err != nil {
-				fails[soln.v] = true
-				fmt.Printf("skipping check: could not write tree for %s (err %s)\n", nv, err)
-				continue
-			}
+	// If we're interrupted, have the sweep kill any in-flight command and
+	// return normally, so that it can put the vendor dir back. A second signal
+	// gets the default behavior.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+	go func() {
+		select {
+		case sig := <-sigc:
+			signal.Stop(sigc)
+			fmt.Fprintf(os.Stderr, "\nReceived %s, cleaning up...\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
-			parts := strings.Split(run, " ")
-			scmd := exec.Command(parts[0], parts[1:]...)
-			out, err := scmd.CombinedOutput()
-			if err != nil {
-				fails[soln.v] = true
-				fmt.Printf("`%s` against %s failed with %s, output:\n%s\n", run, nv, err, string(out))
-			} else {
-				fmt.Printf("%s succeeded\n", nv)
+	start := time.Now()
+	var results []sweep.Result
+	var bis *sweep.Bisection
+	if bisect {
+		bis, err = sweep.Bisect(ctx, opts)
+		if bis != nil {
+			results = bis.Results
+		}
+	} else {
+		results, err = sweep.Check(ctx, opts)
+	}
+	elapsed := time.Since(start)
+	if ctx.Err() != nil {
+		return errInterrupted
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintln(hout, "") // just a spacer
+
+	if declined {
+		fmt.Fprintf(hout, "Not running, as requested; the results below are from solving alone.\n\n")
+	}
+	if n := ncombos - len(results); n > 0 && !bisect {
+		fmt.Fprintf(hout, "Stopped at the first failure, per --fail-fast; %v more were not checked.\n\n", n)
+	}
+
+	if shapes {
+		printShapes(results)
+	}
+
+	report := make([]sweep.Result, len(results))
+	copy(report, results)
+	switch sortBy {
+	case "status":
+		sort.Stable(byStatus(report))
+	case "duration":
+		sort.Stable(byDuration(report))
+	}
+
+	var logs map[string]string
+	if logDir != "" {
+		if logs, err = writeLogs(logDir, results); err != nil {
+			return fmt.Errorf("Failed to write run logs: %s", err)
+		}
+	}
+
+	// Targets that an --override, in a repro line, can't hold at a version,
+	// as it would lose where they're to come from
+	fixed := make(map[gps.ProjectRoot]bool)
+	for _, t := range targets {
+		_, local := locals[t.Root]
+		fixed[t.Root] = local || fovr[t.Root].NetworkName != ""
+	}
+
+	for _, r := range report {
+		if summaryOnly {
+			break
+		}
+
+		nv, key := ppc(r.Combo, ids), r.Combo.String()
+		switch {
+		case r.SolveErr != nil && r.Ran:
+			outcome := paint(green, "passed")
+			if r.RunErr != nil {
+				outcome = fmt.Sprintf("%s with %s", paint(red, "failed"), r.RunErr)
+			}
+			fmt.Fprintf(hout, "%s %s%s: %s\n", nv, paint(red, "failed solving"), tries(r), r.SolveErr)
+			fmt.Fprintf(hout, "`%s`, run against the locked tree anyway, %s, output:\n%s\n", strings.Join(runs, "`, then `"), outcome, string(r.Output))
+		case r.RunErr != nil && logs[key] != "":
+			fmt.Fprintf(hout, "`%s` against %s %s%s with %s, output in %s\n", failedRun(runs, r), nv, paint(red, "failed"), tries(r), r.RunErr, logs[key])
+		case r.SolveErr != nil:
+			fmt.Fprintf(hout, "%s %s%s: %s\n", nv, paint(red, "failed solving"), tries(r), r.SolveErr)
+		case r.WriteErr != nil:
+			fmt.Fprintf(hout, "%s: could not write tree for %s (err %s)\n", paint(yellow, "skipping check"), nv, r.WriteErr)
+		case r.PreRunErr() != nil:
+			fmt.Fprintf(hout, "%s: --pre-run `%s` for %s failed with %s, output:\n%s\n", paint(yellow, "skipping check"), preRun, nv, r.PreRunErr(), string(r.PreRun.Output))
+		case r.RunErr != nil && len(runs) > 1 && len(r.Commands) > 0:
+			fmt.Fprintf(hout, "`%s` (command %v of %v) against %s %s%s with %s\n", failedRun(runs, r), len(r.Commands), len(runs), nv, paint(red, "failed"), tries(r), r.RunErr)
+			for k, cr := range r.Commands {
+				fmt.Fprintf(hout, "output of `%s`:\n%s\n", runs[k], string(cr.Output))
+			}
+		case r.RunErr != nil:
+			fmt.Fprintf(hout, "`%s` against %s %s%s with %s, output:\n%s\n", failedRun(runs, r), nv, paint(red, "failed"), tries(r), r.RunErr, string(r.Output))
+		default:
+			fmt.Fprintf(chatter, "%s %s%s\n", nv, paint(green, "succeeded"), tries(r))
+		}
+		if r.Status() != sweep.StatusPass {
+			if line := reproLine(cmdFlags, r.Combo, given, fixed); line != "" {
+				fmt.Fprintf(hout, "To check just this again: %s\n", line)
 			}
+		}
+	}
 
-			os.RemoveAll(vpath)
-			//os.Rename(vpath, filepath.Join(wd, "vend-"+soln.v.String()))
+	fmt.Fprintln(hout, "")
+	if quiet {
+		err = printTally(hout, report)
+	} else {
+		err = printSummary(hout, report)
+	}
+	if err != nil {
+		return err
+	}
+	printTiming(chatter, elapsed, results)
+	fmt.Fprintln(hout, "")
+	if !quiet {
+		printUnexercised(results)
+	}
+
+	switch {
+	case bl != nil:
+		bl.jsonReport = newJSONReport(targets, results)
+	case format == "json":
+		if err = writeJSON(os.Stdout, targets, results); err != nil {
+			return fmt.Errorf("Failed to write JSON output: %s", err)
+		}
+	case format == "tap":
+		if err = writeTAP(os.Stdout, results, runs); err != nil {
+			return fmt.Errorf("Failed to write TAP output: %s", err)
 		}
 	}
 
-	var succ []gps.Version
-	for _, v := range vl {
-		if !fails[v] {
-			succ = append(succ, v)
+	if junit != "" {
+		if err = writeJUnit(junit, targets, results, runs); err != nil {
+			return fmt.Errorf("Failed to write JUnit report: %s", err)
 		}
 	}
+	if summaryJSON != "" {
+		if err = writeJSONFile(summaryJSON, newJSONReport(targets, results)); err != nil {
+			return fmt.Errorf("Failed to write JSON summary: %s", err)
+		}
+	}
+
+	var all, succ []sweep.Combo
+	var nsolve, nwrite, npre, nrun int
+	for _, r := range results {
+		all = append(all, r.Combo)
+		switch {
+		case r.SolveErr != nil:
+			nsolve++
+		case r.WriteErr != nil:
+			nwrite++
+		case r.PreRunErr() != nil:
+			npre++
+		case r.RunErr != nil:
+			nrun++
+		case r.Status() == sweep.StatusPass:
+			succ = append(succ, r.Combo)
+		}
+	}
+
+	if bis != nil {
+		printBisection(bis, ppi(ids[targets[0].Root]))
+	}
 
+	if quiet {
+		// The tally already said as much
+	} else if len(succ) == len(all) {
+		fmt.Fprintf(hout, "All of the %v %s tried were ok:\n", len(all), noun)
+		printCombos(all)
+	} else if len(succ) > 0 {
+		fmt.Fprintf(hout, "%v of the %v %s tried were ok:\n", len(succ), len(all), noun)
+		printCombos(succ)
+	}
+
+	if n := nsolve + nwrite + npre + nrun; n > 0 {
+		return failedError{
+			n:   n,
+			msg: fmt.Sprintf("%v/%v %s failed (%v solve, %v write, %v pre-run, %v run)", n, len(all), noun, nsolve, nwrite, npre, nrun),
+		}
+	}
 	if len(succ) == 0 {
-		return fmt.Errorf("None of the %v versions tried were ok", len(vl))
-	} else if len(fails) == 0 {
-		fmt.Printf("All of the %v versions tried were ok:\n\t%s\n", len(vl), vl)
-	} else {
-		fmt.Printf("%v of the %v versions tried were ok:\n\t%s\n", len(succ), len(vl), succ)
+		return fmt.Errorf("None of the %v %s tried were ok", len(all), noun)
 	}
 
 	return nil
 }
 
-type simpleRootManifest struct {
-	c   map[gps.ProjectRoot]gps.ProjectConstraint
-	tc  map[gps.ProjectRoot]gps.ProjectConstraint
-	ovr gps.ProjectConstraints
-	ig  map[string]bool
+// stringArray collects the values of a repeatable flag. Unlike a StringSlice,
+// values aren't split on commas, as they may well contain them.
+type stringArray []string
+
+func (a *stringArray) String() string {
+	return strings.Join(*a, " ")
+}
+
+func (a *stringArray) Set(s string) error {
+	*a = append(*a, s)
+	return nil
+}
+
+func (a *stringArray) Type() string {
+	return "stringArray"
+}
+
+// failedError is returned when some of the versions checked failed. The
+// process exits with the number of failures as its status, so that CI can
+// gate on thresholds.
+type failedError struct {
+	n   int
+	msg string
+}
+
+func (e failedError) Error() string {
+	return e.msg
+}
+
+// exitCode is the number of failures, capped so as not to collide with
+// exitNoMatch, or with the statuses that shells reserve for themselves.
+func (e failedError) exitCode() int {
+	if e.n >= exitNoMatch {
+		return exitNoMatch - 1
+	}
+	return e.n
+}
+
+// newest returns up to n of the newest versions in vl, newest first, as a hint
+// at what a constraint could have matched.
+func newest(vl []gps.Version, n int) []string {
+	sorted := make([]gps.Version, len(vl))
+	copy(sorted, vl)
+	gps.SortForUpgrade(sorted)
+
+	var s []string
+	for _, v := range sorted {
+		if len(s) == n {
+			break
+		}
+		s = append(s, v.String())
+	}
+	return s
+}
+
+// noMatchError is returned when no versions of a dep were left to check, once
+// the constraints and filters had been applied. That's more likely a mistake
+// in the invocation than a problem with the project, so it gets an exit
+// status of its own.
+type noMatchError string
+
+func (e noMatchError) Error() string {
+	return string(e)
+}
+
+// exitNoMatch is the exit status for a noMatchError. Lower statuses are the
+// number of versions that failed, so it's the highest one left before those
+// that shells reserve.
+const exitNoMatch = 125
+
+// parseConstraint turns a branch name, plain version, or semver range into a
+// constraint. At most one of them may be given; if none are, the constraint
+// is Any.
+//
+// The same parsing serves the constraint flags and --override, so that they
+// can't drift apart.
+func parseConstraint(branch, version, semver string) (gps.Constraint, error) {
+	var n int
+	for _, s := range []string{branch, version, semver} {
+		if s != "" {
+			n++
+		}
+	}
+
+	switch {
+	case n > 1:
+		return nil, errOneConstraint
+	case branch != "":
+		return gps.NewBranch(branch), nil
+	case version != "":
+		return gps.NewVersion(version), nil
+	case semver != "":
+		c, err := gps.NewSemverConstraint(semver)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid semver constraint", semver)
+		}
+		return c, nil
+	}
+	return gps.Any(), nil
 }
 
-func (m simpleRootManifest) DependencyConstraints() []gps.ProjectConstraint {
-	ds := make([]gps.ProjectConstraint, 0)
-	for _, d := range m.c {
-		ds = append(ds, d)
+// errOneConstraint is returned when more than one type of constraint is given.
+var errOneConstraint = fmt.Errorf("Please specify only one type of constraint - branch, all-branches, version, versions, revisions, or semver")
+
+// runPattern compiles the value s of the regexp flag named flag, which only
+// makes sense with --run. It returns nil if s is empty.
+func runPattern(flag, s string) (*regexp.Regexp, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("%s only makes sense with --run", flag)
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse %s %q: %s", flag, s, err)
 	}
-	return ds
+	return re, nil
 }
 
-func (m simpleRootManifest) TestDependencyConstraints() []gps.ProjectConstraint {
-	ds := make([]gps.ProjectConstraint, 0)
-	for _, d := range m.tc {
-		ds = append(ds, d)
+// errInterrupted is returned when a signal stopped the sweep partway.
+var errInterrupted = fmt.Errorf("Interrupted; stopping early")
+
+// isPrerelease reports whether v is a semver version with a prerelease part,
+// like v1.2.0-rc1.
+func isPrerelease(v gps.Version) bool {
+	if v.Type() != "semver" {
+		return false
 	}
-	return ds
+	sv, err := msemver.NewVersion(v.String())
+	return err == nil && sv.Prerelease() != ""
 }
 
-func (m simpleRootManifest) Overrides() gps.ProjectConstraints {
-	return m.ovr
+// pickVersions selects the versions from vlist whose names are in names,
+// preserving vlist's order. It's an error for any name not to be in vlist.
+func pickVersions(vlist []gps.Version, names []string) ([]gps.Version, error) {
+	want := make(map[string]bool, len(names))
+	for _, n := range names {
+		want[n] = true
+	}
+
+	var vl []gps.Version
+	for _, v := range vlist {
+		if want[v.String()] {
+			vl = append(vl, v)
+			delete(want, v.String())
+		}
+	}
+
+	if len(want) > 0 {
+		var missing []string
+		for _, n := range names {
+			if want[n] {
+				missing = append(missing, n)
+			}
+		}
+		return nil, fmt.Errorf("no such version(s) upstream: %s", strings.Join(missing, ", "))
+	}
+	return vl, nil
 }
 
-func (m simpleRootManifest) IgnorePackages() map[string]bool {
-	return m.ig
+// pickRevisions makes a version to check out of each of revs, in the order
+// given. A revision that a version in vlist points at (even if it's given
+// abbreviated) is paired with the first such version, so that it's reported
+// by name; any other revision must be present in the source.
+func pickRevisions(sm gps.SourceManager, id gps.ProjectIdentifier, vlist []gps.Version, revs []string) ([]gps.Version, error) {
+	var vl []gps.Version
+	var missing []string
+revs:
+	for _, rev := range revs {
+		if rev == "" {
+			return nil, fmt.Errorf("empty revision given")
+		}
+		for _, v := range vlist {
+			if pv, ok := v.(gps.PairedVersion); ok && strings.HasPrefix(pv.Underlying().String(), rev) {
+				vl = append(vl, pv)
+				continue revs
+			}
+		}
+
+		var has bool
+		err := sweep.Retry(retries, func() (err error) {
+			has, err = sm.RevisionPresentIn(id, gps.Revision(rev))
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !has {
+			missing = append(missing, rev)
+			continue
+		}
+		vl = append(vl, gps.Revision(rev))
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("no such revision(s) upstream: %s", strings.Join(missing, ", "))
+	}
+	return vl, nil
 }
 
-func prepManifest(m gps.Manifest) simpleRootManifest {
-	rm := simpleRootManifest{
-		c:  make(map[gps.ProjectRoot]gps.ProjectConstraint),
-		tc: make(map[gps.ProjectRoot]gps.ProjectConstraint),
+// lockFloor makes the constraint for --lock-as-floor: every semver version at
+// or above the one that l locks root to, which is also returned. A lock to a
+// bare revision is taken to be at a semver version in vlist that points at
+// it, if there is one. Branches and other versions have no place in semver
+// order, so a lock to one of those is an error.
+func lockFloor(l gps.Lock, root gps.ProjectRoot, vlist []gps.Version) (gps.Constraint, gps.Version, error) {
+	var lv gps.Version
+	for _, lp := range l.Projects() {
+		if lp.Ident().ProjectRoot == root {
+			lv = lp.Version()
+			break
+		}
+	}
+	if lv == nil {
+		return nil, nil, fmt.Errorf("%s is not in the lock, so --lock-as-floor has no version to start from", root)
+	}
+
+	if rev, ok := lv.(gps.Revision); ok {
+		for _, v := range vlist {
+			if pv, ok := v.(gps.PairedVersion); ok && pv.Type() == "semver" && pv.Underlying() == rev {
+				lv = pv
+				break
+			}
+		}
+		if lv.Type() != "semver" {
+			return nil, nil, fmt.Errorf("%s is locked to revision %s, which no semver version points at, so --lock-as-floor can't place it", root, rev)
+		}
+	}
+	if lv.Type() != "semver" {
+		return nil, nil, fmt.Errorf("%s is locked to %s %s, rather than a semver version, so --lock-as-floor can't place it", root, lv.Type(), lv)
+	}
+
+	c, err := gps.NewSemverConstraint(">=" + lv.String())
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s is locked to %s, which --lock-as-floor could not make a constraint of: %s", root, lv, err)
 	}
+	return c, lv, nil
+}
 
-	if m == nil {
-		return rm
+// contains reports whether s is in l.
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
 	}
+	return false
+}
 
-	for _, d := range m.DependencyConstraints() {
-		rm.c[d.Ident.ProjectRoot] = d
+// shortRev abbreviates a revision from a source of the given VCS, as named by
+// sourceVCS, for display: git hashes are cut to 7 characters, and hg's to 12,
+// as each tool does itself. bzr and svn revisions aren't hashes, so they're
+// returned as-is. If the VCS isn't known, it goes by the revision's shape, and
+// cuts anything that looks like a hash to 7. Revisions that are already short
+// are never padded out.
+func shortRev(vcs, s string) string {
+	n := 7
+	switch vcs {
+	case "git":
+	case "hg":
+		n = 12
+	case "bzr", "svn":
+		return s
+	default:
+		for _, r := range s {
+			if !strings.ContainsRune("0123456789abcdef", r) {
+				return s
+			}
+		}
+	}
+	if len(s) <= n {
+		return s
 	}
-	for _, d := range m.TestDependencyConstraints() {
-		rm.tc[d.Ident.ProjectRoot] = d
+	return s[:n]
+}
+
+// revVCS names the VCS of the source of id, for shortRev. Local repositories
+// are only ever git ones.
+func revVCS(id gps.ProjectIdentifier, locals map[gps.ProjectRoot]string) string {
+	if locals[id.ProjectRoot] != "" {
+		return "git"
 	}
+	return sourceVCS(sourceCacheDir(), id)
+}
 
-	return rm
+// isTerminal reports whether f is a terminal, or at least a character device.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
 }
+
+// byStatus sorts failures first, then versions that couldn't be checked, then
+// passes.
+type byStatus []sweep.Result
+
+func (s byStatus) Len() int           { return len(s) }
+func (s byStatus) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byStatus) Less(i, j int) bool { return s[i].Status() < s[j].Status() }
+
+// byDuration sorts the slowest versions first.
+type byDuration []sweep.Result
+
+func (s byDuration) Len() int           { return len(s) }
+func (s byDuration) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byDuration) Less(i, j int) bool { return s[i].Duration > s[j].Duration }
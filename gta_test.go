@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sdboyer/gps"
+)
+
+func TestShortRev(t *testing.T) {
+	git := "d2abc5c5ca6c1ea5fc5a3e00e1d2dc1a1658b434"
+	tests := []struct {
+		vcs, in, want string
+	}{
+		{"git", git, "d2abc5c"},
+		{"git", "abcd", "abcd"},
+		{"git", "", ""},
+		// hg's hashes are 40 characters too, but displayed with 12
+		{"hg", git, "d2abc5c5ca6c"},
+		{"hg", "abcdef0", "abcdef0"},
+		{"bzr", "joe@example.com-20170101000000-abcdefghijklmnop", "joe@example.com-20170101000000-abcdefghijklmnop"},
+		{"bzr", "12345678901", "12345678901"},
+		{"svn", "123456789", "123456789"},
+		// With no VCS to go by, hashes are taken to be git's
+		{"", git, "d2abc5c"},
+		{"", "abcd", "abcd"},
+		{"", "", ""},
+		{"", "joe@example.com-20170101000000-abcdefghijklmnop", "joe@example.com-20170101000000-abcdefghijklmnop"},
+	}
+
+	for _, tt := range tests {
+		if got := shortRev(tt.vcs, tt.in); got != tt.want {
+			t.Errorf("shortRev(%q, %q) = %q; want %q", tt.vcs, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSourceVCS(t *testing.T) {
+	cache, err := ioutil.TempDir("", "gta-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cache)
+	for dir, meta := range map[string]string{
+		"https---github.com-foo-bar":    ".git",
+		"https---bitbucket.org-foo-hgp": ".hg",
+		"https---launchpad.net-bzrp":    ".bzr",
+		"ssh---git@example.com-foo-bar": ".git",
+	} {
+		if err = os.MkdirAll(filepath.Join(cache, "sources", dir, meta), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		id   gps.ProjectIdentifier
+		want string
+	}{
+		{gps.ProjectIdentifier{ProjectRoot: "github.com/foo/bar"}, "git"},
+		{gps.ProjectIdentifier{ProjectRoot: "bitbucket.org/foo/hgp"}, "hg"},
+		{gps.ProjectIdentifier{ProjectRoot: "launchpad.net/bzrp"}, "bzr"},
+		// From a fork, which is where the revisions come from
+		{gps.ProjectIdentifier{ProjectRoot: "github.com/foo/baz", NetworkName: "ssh://git@example.com/foo/bar"}, "git"},
+		{gps.ProjectIdentifier{ProjectRoot: "github.com/foo/uncached"}, ""},
+	}
+	for _, tt := range tests {
+		if got := sourceVCS(cache, tt.id); got != tt.want {
+			t.Errorf("sourceVCS(%s) = %q; want %q", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestParseConstraint(t *testing.T) {
+	tests := []struct {
+		name                    string
+		branch, version, semver string
+		// The constraint must be of this type, and match these versions and
+		// not those
+		typ      string
+		match    []gps.Version
+		nonmatch []gps.Version
+	}{
+		{
+			name:  "none",
+			typ:   "any",
+			match: []gps.Version{gps.NewBranch("master"), gps.NewVersion("v1.0.0")},
+		},
+		{
+			name:     "branch",
+			branch:   "master",
+			typ:      "branch",
+			match:    []gps.Version{gps.NewBranch("master")},
+			nonmatch: []gps.Version{gps.NewBranch("dev"), gps.NewVersion("master")},
+		},
+		{
+			name:     "version",
+			version:  "some-tag",
+			typ:      "version",
+			match:    []gps.Version{gps.NewVersion("some-tag")},
+			nonmatch: []gps.Version{gps.NewVersion("other-tag"), gps.NewBranch("some-tag")},
+		},
+		{
+			name:     "semver",
+			semver:   "^1.2.0",
+			typ:      "semver",
+			match:    []gps.Version{gps.NewVersion("v1.2.0"), gps.NewVersion("v1.9.1")},
+			nonmatch: []gps.Version{gps.NewVersion("v1.1.0"), gps.NewVersion("v2.0.0")},
+		},
+	}
+
+	for _, tt := range tests {
+		c, err := parseConstraint(tt.branch, tt.version, tt.semver)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		switch tt.typ {
+		case "any":
+			if !gps.IsAny(c) {
+				t.Errorf("%s: got %s; want any", tt.name, c)
+			}
+		case "semver":
+			if _, ok := c.(gps.Version); ok {
+				t.Errorf("%s: got the version %s; want a semver range", tt.name, c)
+			}
+		default:
+			if v, ok := c.(gps.Version); !ok || v.Type() != tt.typ {
+				t.Errorf("%s: got %s (%T); want a %s", tt.name, c, c, tt.typ)
+			}
+		}
+		for _, v := range tt.match {
+			if !c.Matches(v) {
+				t.Errorf("%s: %s doesn't match %s (%s)", tt.name, c, v, v.Type())
+			}
+		}
+		for _, v := range tt.nonmatch {
+			if c.Matches(v) {
+				t.Errorf("%s: %s matches %s (%s)", tt.name, c, v, v.Type())
+			}
+		}
+	}
+}
+
+func TestParseConstraintErrors(t *testing.T) {
+	tests := []struct {
+		name                    string
+		branch, version, semver string
+		want                    error
+	}{
+		{name: "branch and version", branch: "master", version: "some-tag", want: errOneConstraint},
+		{name: "branch and semver", branch: "master", semver: "^1.0.0", want: errOneConstraint},
+		{name: "version and semver", version: "some-tag", semver: "^1.0.0", want: errOneConstraint},
+		{name: "all three", branch: "master", version: "some-tag", semver: "^1.0.0", want: errOneConstraint},
+		{name: "invalid semver", semver: "not a version"},
+	}
+
+	for _, tt := range tests {
+		c, err := parseConstraint(tt.branch, tt.version, tt.semver)
+		if err == nil {
+			t.Errorf("%s: got %s; expected an error", tt.name, c)
+		} else if tt.want != nil && err != tt.want {
+			t.Errorf("%s: got error %q; want %q", tt.name, err, tt.want)
+		}
+	}
+}
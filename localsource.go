@@ -0,0 +1,490 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sdboyer/gps"
+)
+
+// localDep works out whether arg names a dep in a local directory, rather
+// than by import path: either as <import path>=<dir>, or as just a directory,
+// which then has to be on a GOPATH for its import path to be known. If it
+// does, the import path and the directory are returned; if not, both are
+// empty.
+func localDep(arg, gopath string) (gps.ProjectRoot, string, error) {
+	var ip, dir string
+	if k := strings.Index(arg, "="); k > 0 {
+		ip, dir = arg[:k], arg[k+1:]
+	} else if fi, err := os.Stat(arg); err == nil && fi.IsDir() {
+		dir = arg
+	} else {
+		return "", "", nil
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", fmt.Errorf("Could not resolve %s: %s", dir, err)
+	}
+	top, err := git(abs, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", "", fmt.Errorf("%s is not a git repository, so it can't provide versions: %s", dir, err)
+	}
+	if filepath.Clean(strings.TrimSpace(string(top))) != abs {
+		return "", "", fmt.Errorf("%s is not the root of its git repository, which is at %s", dir, strings.TrimSpace(string(top)))
+	}
+
+	if ip == "" {
+		if ip, err = importRoot(abs, gopath); err != nil {
+			return "", "", fmt.Errorf("%s is not inside a GOPATH, so its import path isn't known; give it as <import path>=%s", dir, dir)
+		}
+	}
+	return gps.ProjectRoot(ip), abs, nil
+}
+
+// git runs git with args in the repository at dir, and returns its output.
+func git(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil && stderr.Len() > 0 {
+		err = fmt.Errorf("%s", strings.TrimSpace(stderr.String()))
+	}
+	return out, err
+}
+
+// A localSourceManager serves some projects from local git repositories, and
+// passes everything else through to the SourceManager it wraps. gps has no way
+// of its own to use a local repository as a source.
+//
+// Versions are the repository's local branches and tags. A repository's trees
+// are exported by revision into a temp dir the first time they're needed, and
+// are analyzed from there; they're removed by release.
+type localSourceManager struct {
+	gps.SourceManager
+	an gps.ProjectAnalyzer
+
+	// offline, if set, is the source cache dir. Every project that isn't
+	// given as a local repository is then served from its clone in the cache,
+	// in the same way, and nothing that could go to the network is passed
+	// through.
+	offline string
+
+	// Guards repos, which projects from the cache are added to as they're
+	// first needed
+	mu    sync.Mutex
+	repos map[gps.ProjectRoot]*localRepo
+}
+
+type localRepo struct {
+	root gps.ProjectRoot
+	dir  string
+
+	// Guards everything below, as the solver may be used from several
+	// goroutines at once
+	mu       sync.Mutex
+	versions []gps.Version
+	tmp      string
+	trees    map[gps.Revision]string
+}
+
+func newLocalSourceManager(sm gps.SourceManager, an gps.ProjectAnalyzer, dirs map[gps.ProjectRoot]string) *localSourceManager {
+	lsm := &localSourceManager{
+		SourceManager: sm,
+		an:            an,
+		repos:         make(map[gps.ProjectRoot]*localRepo),
+	}
+	for root, dir := range dirs {
+		lsm.repos[root] = newLocalRepo(root, dir)
+	}
+	return lsm
+}
+
+func newLocalRepo(root gps.ProjectRoot, dir string) *localRepo {
+	return &localRepo{
+		root:  root,
+		dir:   dir,
+		trees: make(map[gps.Revision]string),
+	}
+}
+
+// release removes the trees exported from the local repositories.
+func (lsm *localSourceManager) release() {
+	lsm.mu.Lock()
+	defer lsm.mu.Unlock()
+	for _, lr := range lsm.repos {
+		if lr.tmp != "" {
+			os.RemoveAll(lr.tmp)
+		}
+	}
+}
+
+// repo returns the local repository that id is served from, or nil if it's
+// to be passed through. Offline, that's never the case; a project that isn't
+// in the cache is an error.
+func (lsm *localSourceManager) repo(id gps.ProjectIdentifier) (*localRepo, error) {
+	lsm.mu.Lock()
+	defer lsm.mu.Unlock()
+	if lr, has := lsm.repos[id.ProjectRoot]; has {
+		return lr, nil
+	}
+	if lsm.offline == "" {
+		return nil, nil
+	}
+
+	name := id.NetworkName
+	if name == "" {
+		name = string(id.ProjectRoot)
+	}
+	dir, err := cachedSource(lsm.offline, name)
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("%s is not in the source cache, and can't be fetched offline (run gta warm for it first)", name)
+	}
+	lr := newLocalRepo(id.ProjectRoot, dir)
+	lsm.repos[id.ProjectRoot] = lr
+	return lr, nil
+}
+
+func (lsm *localSourceManager) SourceExists(id gps.ProjectIdentifier) (bool, error) {
+	lr, err := lsm.repo(id)
+	if err != nil {
+		return false, err
+	}
+	if lr != nil {
+		return true, nil
+	}
+	return lsm.SourceManager.SourceExists(id)
+}
+
+func (lsm *localSourceManager) SyncSourceFor(id gps.ProjectIdentifier) error {
+	lr, err := lsm.repo(id)
+	if err != nil || lr != nil {
+		return err
+	}
+	return lsm.SourceManager.SyncSourceFor(id)
+}
+
+func (lsm *localSourceManager) ListVersions(id gps.ProjectIdentifier) ([]gps.Version, error) {
+	lr, err := lsm.repo(id)
+	if err != nil {
+		return nil, err
+	}
+	if lr != nil {
+		lr.mu.Lock()
+		defer lr.mu.Unlock()
+		vl, err := lr.listVersions()
+		// Callers sort the list in place
+		return append([]gps.Version(nil), vl...), err
+	}
+	return lsm.SourceManager.ListVersions(id)
+}
+
+func (lsm *localSourceManager) RevisionPresentIn(id gps.ProjectIdentifier, r gps.Revision) (bool, error) {
+	lr, err := lsm.repo(id)
+	if err != nil {
+		return false, err
+	}
+	if lr != nil {
+		_, err := git(lr.dir, "cat-file", "-e", string(r)+"^{commit}")
+		return err == nil, nil
+	}
+	return lsm.SourceManager.RevisionPresentIn(id, r)
+}
+
+func (lsm *localSourceManager) ListPackages(id gps.ProjectIdentifier, v gps.Version) (gps.PackageTree, error) {
+	lr, err := lsm.repo(id)
+	if err != nil {
+		return gps.PackageTree{}, err
+	}
+	if lr != nil {
+		dir, err := lr.tree(v)
+		if err != nil {
+			return gps.PackageTree{}, err
+		}
+		return listLocalPackages(dir, string(lr.root)), nil
+	}
+	return lsm.SourceManager.ListPackages(id, v)
+}
+
+func (lsm *localSourceManager) GetManifestAndLock(id gps.ProjectIdentifier, v gps.Version) (gps.Manifest, gps.Lock, error) {
+	lr, err := lsm.repo(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if lr != nil {
+		dir, err := lr.tree(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		m, l, err := lsm.an.DeriveManifestAndLock(dir, lr.root)
+		// The solver expects a manifest, even if an empty one, as gps's own
+		// sources provide
+		if err == nil && m == nil {
+			m = gps.SimpleManifest{}
+		}
+		return m, l, err
+	}
+	return lsm.SourceManager.GetManifestAndLock(id, v)
+}
+
+func (lsm *localSourceManager) ExportProject(id gps.ProjectIdentifier, v gps.Version, to string) error {
+	lr, err := lsm.repo(id)
+	if err != nil {
+		return err
+	}
+	if lr != nil {
+		lr.mu.Lock()
+		defer lr.mu.Unlock()
+		rev, err := lr.revision(v)
+		if err != nil {
+			return err
+		}
+		return lr.export(rev, to)
+	}
+	return lsm.SourceManager.ExportProject(id, v, to)
+}
+
+func (lsm *localSourceManager) DeduceProjectRoot(ip string) (gps.ProjectRoot, error) {
+	lsm.mu.Lock()
+	for root := range lsm.repos {
+		if ip == string(root) || strings.HasPrefix(ip, string(root)+"/") {
+			lsm.mu.Unlock()
+			return root, nil
+		}
+	}
+	lsm.mu.Unlock()
+	if lsm.offline == "" {
+		return lsm.SourceManager.DeduceProjectRoot(ip)
+	}
+	return cachedRoot(lsm.offline, ip)
+}
+
+// listVersions lists the repository's local branches and tags, each paired
+// with the revision it points at.
+func (lr *localRepo) listVersions() ([]gps.Version, error) {
+	if lr.versions != nil {
+		return lr.versions, nil
+	}
+
+	// For annotated tags, the revision is that of the commit they point at
+	out, err := git(lr.dir, "for-each-ref", "--format=%(objectname) %(*objectname) %(refname)", "refs/heads", "refs/tags")
+	if err != nil {
+		return nil, fmt.Errorf("could not list the versions in %s: %s", lr.dir, err)
+	}
+
+	vl := []gps.Version{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		rev, ref := gps.Revision(fields[0]), fields[len(fields)-1]
+		if len(fields) == 3 {
+			rev = gps.Revision(fields[1])
+		}
+
+		switch {
+		case strings.HasPrefix(ref, "refs/heads/"):
+			vl = append(vl, gps.NewBranch(strings.TrimPrefix(ref, "refs/heads/")).Is(rev))
+		case strings.HasPrefix(ref, "refs/tags/"):
+			vl = append(vl, gps.NewVersion(strings.TrimPrefix(ref, "refs/tags/")).Is(rev))
+		}
+	}
+	lr.versions = vl
+	return vl, nil
+}
+
+// revision resolves v to the revision it stands for in the repository.
+func (lr *localRepo) revision(v gps.Version) (gps.Revision, error) {
+	switch tv := v.(type) {
+	case gps.Revision:
+		return tv, nil
+	case gps.PairedVersion:
+		return tv.Underlying(), nil
+	}
+
+	vl, err := lr.listVersions()
+	if err != nil {
+		return "", err
+	}
+	for _, lv := range vl {
+		if lv.Type() == v.Type() && lv.String() == v.String() {
+			return lv.(gps.PairedVersion).Underlying(), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no version %s", lr.dir, v)
+}
+
+// tree returns the path of a tree of the repository at v, exporting it first
+// if that's not been done already.
+func (lr *localRepo) tree(v gps.Version) (string, error) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	rev, err := lr.revision(v)
+	if err != nil {
+		return "", err
+	}
+	if dir, has := lr.trees[rev]; has {
+		return dir, nil
+	}
+
+	if lr.tmp == "" {
+		if lr.tmp, err = ioutil.TempDir("", "gta-local-"); err != nil {
+			return "", err
+		}
+	}
+	dir := filepath.Join(lr.tmp, string(rev))
+	if err = lr.export(rev, dir); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	lr.trees[rev] = dir
+	return dir, nil
+}
+
+// export writes out the tree of the repository at rev to the dir to, with git
+// archive, so that the repository's own working tree and index are never
+// touched.
+func (lr *localRepo) export(rev gps.Revision, to string) error {
+	cmd := exec.Command("git", "-C", lr.dir, "archive", "--format=tar", string(rev))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	err = untar(out, to)
+	// Drain whatever's left, so that git isn't blocked writing it
+	io.Copy(ioutil.Discard, out)
+	if werr := cmd.Wait(); werr != nil {
+		return fmt.Errorf("could not export %s at %s: %s", lr.dir, rev, strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		return fmt.Errorf("could not export %s at %s: %s", lr.dir, rev, err)
+	}
+	return nil
+}
+
+// untar extracts the tar stream r into the dir to.
+func untar(r io.Reader, to string) error {
+	if err := os.MkdirAll(to, 0777); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(hdr.Name)
+		if name == "." || path.IsAbs(name) || strings.HasPrefix(name, "../") {
+			continue
+		}
+		dst := filepath.Join(to, filepath.FromSlash(name))
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(dst, 0777)
+		case tar.TypeSymlink:
+			err = os.Symlink(hdr.Linkname, dst)
+		case tar.TypeReg, tar.TypeRegA:
+			var f *os.File
+			f, err = os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+			if err == nil {
+				_, err = io.Copy(f, tr)
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// listLocalPackages does for a tree exported from a local repository what gps
+// does for any other source: it lists the packages in it, and what each
+// imports. Imports are gathered across all build tags, as gps does; a
+// directory where that makes for more than one package (usually because of a
+// "+build ignore" main) is read for the current platform only, instead.
+func listLocalPackages(dir, root string) gps.PackageTree {
+	ctx := build.Default
+	ctx.GOROOT, ctx.GOPATH = "", ""
+	ctx.UseAllFiles = true
+	plain := build.Default
+	plain.GOROOT, plain.GOPATH = "", ""
+
+	ptree := gps.PackageTree{
+		ImportRoot: root,
+		Packages:   make(map[string]gps.PackageOrErr),
+	}
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		name := fi.Name()
+		if p != dir && (name == "vendor" || name == "Godeps" || strings.HasPrefix(name, ".")) {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return nil
+		}
+		ip := path.Join(root, filepath.ToSlash(rel))
+
+		bp, err := ctx.ImportDir(p, build.ImportComment)
+		if _, ok := err.(*build.MultiplePackageError); ok {
+			bp, err = plain.ImportDir(p, build.ImportComment)
+		}
+		if err != nil {
+			ptree.Packages[ip] = gps.PackageOrErr{Err: err}
+			return nil
+		}
+
+		var timps []string
+		seen := make(map[string]bool)
+		for _, imp := range append(append([]string{}, bp.TestImports...), bp.XTestImports...) {
+			if !seen[imp] {
+				seen[imp] = true
+				timps = append(timps, imp)
+			}
+		}
+		ptree.Packages[ip] = gps.PackageOrErr{
+			P: gps.Package{
+				ImportPath:  ip,
+				CommentPath: bp.ImportComment,
+				Name:        bp.Name,
+				Imports:     bp.Imports,
+				TestImports: timps,
+			},
+		}
+		return nil
+	})
+	return ptree
+}
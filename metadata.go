@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/glide/cfg"
+	"github.com/Masterminds/glide/dependency"
+	"github.com/Masterminds/glide/godep"
+	gpath "github.com/Masterminds/glide/path"
+	"github.com/sdboyer/gps"
+)
+
+// A MetadataLoader reads a package manager's metadata files into the manifest
+// and lock of the root project.
+type MetadataLoader interface {
+	// Detect reports whether dir has the package manager's metadata files.
+	Detect(dir string) bool
+	// Load derives the manifest and lock for the project at root in dir.
+	Load(dir string, root gps.ProjectRoot) (gps.Manifest, gps.Lock, error)
+}
+
+type namedLoader struct {
+	name string
+	MetadataLoader
+}
+
+// metadataLoaders are the package managers that --pm may name, in the order
+// in which their files are looked for when detecting one. The built-in ones
+// are last, so that a project with files for one of those, as well as for a
+// loader registered with RegisterMetadataLoader, is read with the latter.
+var metadataLoaders = []namedLoader{
+	{"glide", loaderFuncs{hasGlide, dependency.Analyzer{}.DeriveManifestAndLock}},
+	{"dep", loaderFuncs{hasDep, dirOnly(loadDep)}},
+	{"godep", loaderFuncs{godep.Has, dirOnly(loadGodep)}},
+	{"glock", loaderFuncs{hasGlock, dirOnly(loadGlock)}},
+}
+
+// nregistered is the number of loaders registered by RegisterMetadataLoader,
+// which go ahead of the built-in ones.
+var nregistered int
+
+// RegisterMetadataLoader makes a loader for a package manager gta doesn't know
+// of available to --pm, under name, and to detection. It's meant to be called
+// from the init function of a file of the loader's own, added to the build;
+// loaders are looked for in the order they're registered. It panics if name is
+// already taken.
+func RegisterMetadataLoader(name string, l MetadataLoader) {
+	if name == "" || name == "none" || findLoader(name) != nil {
+		panic(fmt.Sprintf("gta: metadata loader named %q registered twice, or under a reserved name", name))
+	}
+	metadataLoaders = append(metadataLoaders, namedLoader{})
+	copy(metadataLoaders[nregistered+1:], metadataLoaders[nregistered:])
+	metadataLoaders[nregistered] = namedLoader{name, l}
+	nregistered++
+}
+
+func findLoader(name string) MetadataLoader {
+	for _, nl := range metadataLoaders {
+		if nl.name == name {
+			return nl.MetadataLoader
+		}
+	}
+	return nil
+}
+
+// pmNames lists the names that --pm takes, other than none, for messages.
+func pmNames() string {
+	names := make([]string, len(metadataLoaders))
+	for k, nl := range metadataLoaders {
+		names[k] = nl.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// loadMetadata derives the manifest and lock for the root project in dir from
+// the metadata files of the named package manager. If pm is empty, the package
+// manager is detected from the files that are present.
+func loadMetadata(pm, dir string, root gps.ProjectRoot) (gps.Manifest, gps.Lock, error) {
+	if pm != "" {
+		l := findLoader(pm)
+		if l == nil {
+			return nil, nil, fmt.Errorf("%q is not a supported package manager; must be one of %s", pm, pmNames())
+		}
+		if !l.Detect(dir) {
+			return nil, nil, fmt.Errorf("--pm %s was specified, but there are no %s metadata files in %s", pm, pm, dir)
+		}
+		return l.Load(dir, root)
+	}
+
+	for _, nl := range metadataLoaders {
+		if nl.Detect(dir) {
+			return nl.Load(dir, root)
+		}
+	}
+	// glide's analyzer falls back to the files of some other package
+	// managers, too, so it gets the last word
+	return dependency.Analyzer{}.DeriveManifestAndLock(dir, root)
+}
+
+// loaderFuncs is a MetadataLoader made of a pair of functions, as the built-in
+// ones are.
+type loaderFuncs struct {
+	detect func(dir string) bool
+	load   func(dir string, root gps.ProjectRoot) (gps.Manifest, gps.Lock, error)
+}
+
+func (l loaderFuncs) Detect(dir string) bool {
+	return l.detect(dir)
+}
+
+func (l loaderFuncs) Load(dir string, root gps.ProjectRoot) (gps.Manifest, gps.Lock, error) {
+	return l.load(dir, root)
+}
+
+// dirOnly adapts a loader that has no use for the project root.
+func dirOnly(load func(dir string) (gps.Manifest, gps.Lock, error)) func(string, gps.ProjectRoot) (gps.Manifest, gps.Lock, error) {
+	return func(dir string, _ gps.ProjectRoot) (gps.Manifest, gps.Lock, error) {
+		return load(dir)
+	}
+}
+
+func hasGlide(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, gpath.GlideFile))
+	return err == nil
+}
+
+// loadGodep builds a manifest and lock from Godeps/Godeps.json. As godep has no
+// notion of constraints, the manifest only names the deps; the revisions in the
+// lock are used as preferred versions.
+func loadGodep(dir string) (gps.Manifest, gps.Lock, error) {
+	d, l, err := godep.AsMetadataPair(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &cfg.Config{Name: dir, Imports: d}, l, nil
+}
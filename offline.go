@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sdboyer/gps"
+)
+
+// sourceSanitizer turns a source URL into the name of its dir in the cache, as
+// gps does.
+var sourceSanitizer = strings.NewReplacer(":", "-", "/", "-", "+", "-")
+
+// cachedSource finds the clone of a source in the cache dir, as
+// cachedSourceDir does, but only a git clone can be used; it returns "" if
+// there's no clone at all.
+func cachedSource(cache, name string) (string, error) {
+	dir := cachedSourceDir(cache, name)
+	if dir == "" {
+		return "", nil
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		return "", fmt.Errorf("the cached source for %s, %s, is not a git repository; only git sources can be used offline", name, dir)
+	}
+	return dir, nil
+}
+
+// cachedSourceDir finds the clone of a source, of whatever VCS, in the cache
+// dir, where gps keeps each one under its URL. name is either a URL, or a
+// project root, for which the URLs that gps would try are tried in the same
+// order; gopkg.in roots are looked for under the github repository behind
+// them. It returns "" if there's no clone.
+//
+// Vanity import paths, which gps would resolve to a source over the network,
+// are only found if their source is at the same path.
+func cachedSourceDir(cache, name string) string {
+	urls := []string{name}
+	if !strings.Contains(name, "://") {
+		hp := gopkginSource(name)
+		urls = []string{"https://" + hp, "ssh://git@" + hp, "ssh://" + hp, "git://" + hp, "http://" + hp}
+	}
+	for _, u := range urls {
+		dir := filepath.Join(cache, "sources", sourceSanitizer.Replace(u))
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+// sourceVCS tells which VCS the source of id uses, from the clone of it in
+// the cache dir: "git", "hg", "bzr" or "svn", or "" if there's no clone to
+// tell by. gps knows, but doesn't say.
+func sourceVCS(cache string, id gps.ProjectIdentifier) string {
+	name := id.NetworkName
+	if name == "" {
+		name = string(id.ProjectRoot)
+	}
+	dir := cachedSourceDir(cache, name)
+	if dir == "" {
+		return ""
+	}
+	for _, vcs := range []string{"git", "hg", "bzr", "svn"} {
+		if _, err := os.Stat(filepath.Join(dir, "."+vcs)); err == nil {
+			return vcs
+		}
+	}
+	return ""
+}
+
+// gopkginSource returns the github path that a gopkg.in root is served from,
+// or root itself for anything else.
+func gopkginSource(root string) string {
+	p := strings.TrimPrefix(root, "gopkg.in/")
+	if p == root {
+		return root
+	}
+	if k := strings.LastIndex(p, ".v"); k > 0 {
+		p = p[:k]
+	}
+	if !strings.Contains(p, "/") {
+		p = "go-" + p + "/" + p
+	}
+	return "github.com/" + p
+}
+
+// cachedRoot works out the project root of the import path ip without the
+// network, which gps can't: it's the shortest leading part of ip that has a
+// clone in the cache dir.
+func cachedRoot(cache, ip string) (gps.ProjectRoot, error) {
+	elems := strings.Split(ip, "/")
+	for k := 1; k <= len(elems); k++ {
+		root := strings.Join(elems[:k], "/")
+		dir, err := cachedSource(cache, root)
+		if err != nil {
+			return "", err
+		}
+		if dir != "" {
+			return gps.ProjectRoot(root), nil
+		}
+	}
+	return "", fmt.Errorf("no source for %s is in the cache, and it can't be fetched offline (run gta warm for it first)", ip)
+}
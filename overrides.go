@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/glide/cfg"
+	"github.com/sdboyer/gps"
+	"gopkg.in/yaml.v2"
+)
+
+// overridesFileNames are the names of the files, in the project root, from
+// which gta will read overrides if no path is explicitly given, in the order
+// they're looked for. Only the first of them that exists is read.
+var overridesFileNames = []string{".gta-overrides.toml", ".gta-overrides.yaml"}
+
+type overridesDoc struct {
+	Overrides cfg.Dependencies `yaml:"overrides"`
+}
+
+// readOverrides reads overrides from the file at the given path or, if path is
+// empty, from the first of the default overrides files in dir that exists, if
+// any does. A file whose name ends in .toml is read as TOML, and any other as
+// YAML.
+func readOverrides(dir, path string) (gps.ProjectConstraints, error) {
+	explicit := path != ""
+	if !explicit {
+		for _, name := range overridesFileNames {
+			p := filepath.Join(dir, name)
+			if _, err := os.Stat(p); err == nil {
+				if path != "" {
+					fmt.Fprintf(os.Stderr, "Warning: ignoring %s, as overrides are read from %s\n", p, path)
+					continue
+				}
+				path = p
+			}
+		}
+		if path == "" {
+			return nil, nil
+		}
+	}
+
+	if filepath.Ext(path) == ".toml" {
+		return readTOMLOverrides(path)
+	}
+
+	yml, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read overrides file %s: %s", path, err)
+	}
+
+	of := overridesDoc{}
+	if err = yaml.Unmarshal(yml, &of); err != nil {
+		return nil, fmt.Errorf("Could not parse overrides file %s: %s", path, err)
+	}
+
+	ovr := make(gps.ProjectConstraints, len(of.Overrides))
+	for _, d := range of.Overrides {
+		if d.Name == "" {
+			return nil, fmt.Errorf("Override in %s is missing a package name", path)
+		}
+		if d.IsUnconstrained() && d.Repository == "" {
+			return nil, fmt.Errorf("Override for %s in %s must specify a repo, branch, or version", d.Name, path)
+		}
+
+		// Only set a constraint if one was actually given; otherwise, the
+		// override would loosen any existing constraint to Any.
+		pp := gps.ProjectProperties{NetworkName: d.Repository}
+		if !d.IsUnconstrained() {
+			pp.Constraint = d.GetConstraint()
+		}
+		ovr[gps.ProjectRoot(d.Name)] = pp
+	}
+
+	return ovr, nil
+}
+
+// readTOMLOverrides reads overrides from a TOML file at path, in which each is
+// an [[override]], as in dep's Gopkg.toml: a name, and a source, version,
+// branch, or revision, which mean the same as they do for dep.
+func readTOMLOverrides(path string) (gps.ProjectConstraints, error) {
+	tables, err := readTOML(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read overrides file %s: %s", path, err)
+	}
+
+	ovr := make(gps.ProjectConstraints)
+	for _, t := range tables {
+		if !t.array || t.name != "override" {
+			continue
+		}
+		name := t.str("name")
+		if name == "" {
+			return nil, fmt.Errorf("Override in %s is missing a name", path)
+		}
+		c, err := depConstraint(t)
+		if err != nil {
+			return nil, fmt.Errorf("Override for %s in %s: %s", name, path, err)
+		}
+		if c == nil && t.str("source") == "" {
+			return nil, fmt.Errorf("Override for %s in %s must specify a source, version, branch, or revision", name, path)
+		}
+		ovr[gps.ProjectRoot(name)] = gps.ProjectProperties{
+			NetworkName: t.str("source"),
+			Constraint:  c,
+		}
+	}
+
+	return ovr, nil
+}
+
+// parseOverride parses the value of an --override flag, which has the form
+// root@constraint. The constraint may be prefixed by its type, as in
+// root@branch=master or root@version=some-tag; otherwise, it's taken to be a
+// semver constraint, as in root@^1.2.0.
+func parseOverride(s string) (gps.ProjectRoot, gps.ProjectProperties, error) {
+	var pp gps.ProjectProperties
+	at := strings.Index(s, "@")
+	if at < 1 || at == len(s)-1 {
+		return "", pp, fmt.Errorf("--override %q is not of the form root@constraint", s)
+	}
+	root, cs := s[:at], s[at+1:]
+
+	c, err := parseConstraint(splitConstraint(cs))
+	if err != nil {
+		return "", pp, fmt.Errorf("--override for %s: %s", root, err)
+	}
+	pp.Constraint = c
+	return gps.ProjectRoot(root), pp, nil
+}
+
+// parseVersionOverride parses the value of a --version-override flag, which
+// has the form version:root@constraint, with the override the same as for
+// --override.
+func parseVersionOverride(s string) (string, gps.ProjectRoot, gps.ProjectProperties, error) {
+	colon := strings.Index(s, ":")
+	if colon < 1 {
+		return "", "", gps.ProjectProperties{}, fmt.Errorf("--version-override %q is not of the form version:root@constraint", s)
+	}
+	root, pp, err := parseOverride(s[colon+1:])
+	if err != nil {
+		return "", "", pp, fmt.Errorf("%s, in --version-override %q", err, s)
+	}
+	return s[:colon], root, pp, nil
+}
+
+// parseVia parses the value of --via, which has the form root[@constraint].
+// Without a constraint, root is held at the version it's locked to in l.
+func parseVia(s string, l gps.Lock) (gps.ProjectRoot, gps.Constraint, error) {
+	if strings.Contains(s, "@") {
+		root, pp, err := parseOverride(s)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s, in --via %q", err, s)
+		}
+		return root, pp.Constraint, nil
+	}
+
+	root := gps.ProjectRoot(s)
+	if l != nil {
+		for _, lp := range l.Projects() {
+			if lp.Ident().ProjectRoot == root {
+				return root, lp.Version(), nil
+			}
+		}
+	}
+	return "", nil, fmt.Errorf("--via %s needs a version to hold it at, as it's not in the project's lock; give one, as --via %s@<constraint>", s, s)
+}
+
+// splitConstraint splits a constraint given as a string, which may be
+// prefixed by its type, as in branch=master or version=some-tag, into the
+// arguments for parseConstraint. Without a prefix, it's a semver constraint.
+func splitConstraint(cs string) (branch, version, semver string) {
+	switch {
+	case strings.HasPrefix(cs, "branch="):
+		branch = strings.TrimPrefix(cs, "branch=")
+	case strings.HasPrefix(cs, "version="):
+		version = strings.TrimPrefix(cs, "version=")
+	default:
+		semver = strings.TrimPrefix(cs, "semver=")
+	}
+	return
+}
+
+// sourceIdent identifies root as the solver will see it, with the source it
+// will come from: that of an override, if one names a source, or else that of
+// the project's manifest, if it names one. It's the upstream source by root
+// otherwise.
+func sourceIdent(root gps.ProjectRoot, m gps.Manifest, ovr gps.ProjectConstraints) gps.ProjectIdentifier {
+	id := gps.ProjectIdentifier{ProjectRoot: root}
+	if m != nil {
+		for _, d := range append(m.DependencyConstraints(), m.TestDependencyConstraints()...) {
+			if d.Ident.ProjectRoot == root && d.Ident.NetworkName != "" {
+				id.NetworkName = d.Ident.NetworkName
+			}
+		}
+	}
+	if rm, ok := m.(gps.RootManifest); ok {
+		if pp, has := rm.Overrides()[root]; has && pp.NetworkName != "" {
+			id.NetworkName = pp.NetworkName
+		}
+	}
+	if pp, has := ovr[root]; has && pp.NetworkName != "" {
+		id.NetworkName = pp.NetworkName
+	}
+	return id
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gta-overrides")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ovr, err := readOverrides(dir, "")
+	if err != nil || ovr != nil {
+		t.Fatalf("with no overrides file, got %v, %v; want nil, nil", ovr, err)
+	}
+
+	writeFile(t, filepath.Join(dir, ".gta-overrides.yaml"), `overrides:
+- package: github.com/foo/baz
+  version: v1.0.0
+`)
+	ovr, err = readOverrides(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := ovr["github.com/foo/baz"].Constraint; c == nil || c.String() != "v1.0.0" {
+		t.Errorf("from YAML, got constraint %v for github.com/foo/baz; want v1.0.0", c)
+	}
+
+	// The TOML file is looked for first
+	writeFile(t, filepath.Join(dir, ".gta-overrides.toml"), `
+[[override]]
+  name = "github.com/foo/baz"
+  source = "https://github.com/me/baz"
+  branch = "fix-thing"
+`)
+	ovr, err = readOverrides(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pp := ovr["github.com/foo/baz"]
+	if pp.NetworkName != "https://github.com/me/baz" {
+		t.Errorf("from TOML, got source %q for github.com/foo/baz; want https://github.com/me/baz", pp.NetworkName)
+	}
+	if pp.Constraint == nil || pp.Constraint.String() != "fix-thing" {
+		t.Errorf("from TOML, got constraint %v for github.com/foo/baz; want branch fix-thing", pp.Constraint)
+	}
+
+	bad := filepath.Join(dir, "bad.toml")
+	writeFile(t, bad, `
+[[override]]
+  name = "github.com/foo/baz"
+`)
+	if _, err = readOverrides(dir, bad); err == nil {
+		t.Errorf("expected an error for an override with neither a source nor a constraint")
+	}
+
+	if _, err = readOverrides(dir, filepath.Join(dir, "missing.toml")); err == nil {
+		t.Errorf("expected an error for an explicit overrides file that doesn't exist")
+	}
+}
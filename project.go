@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// isGoProject reports whether there are any Go source files at or below dir,
+// skipping vendor directories and any directories the go tool would ignore.
+func isGoProject(dir string) bool {
+	var found bool
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || found {
+			return filepath.SkipDir
+		}
+
+		name := fi.Name()
+		if fi.IsDir() {
+			if path != dir && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if strings.HasSuffix(name, ".go") {
+			found = true
+			return filepath.SkipDir
+		}
+		return nil
+	})
+
+	return found
+}
+
+// projectImports returns the set of all the import paths used by the packages
+// at or below dir, including their tests, skipping the same directories as
+// isGoProject.
+func projectImports(dir string) map[string]bool {
+	imps := make(map[string]bool)
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return filepath.SkipDir
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+
+		name := fi.Name()
+		if path != dir && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+			return filepath.SkipDir
+		}
+
+		// Whatever could be read is still of use, even if there was an error
+		p, _ := build.ImportDir(path, 0)
+		if p == nil {
+			return nil
+		}
+		for _, l := range [][]string{p.Imports, p.TestImports, p.XTestImports} {
+			for _, imp := range l {
+				imps[imp] = true
+			}
+		}
+		return nil
+	})
+
+	return imps
+}
+
+// projectPackages returns the import paths of the packages at or below dir,
+// whose import path is root, skipping the same directories as isGoProject.
+func projectPackages(dir, root string) map[string]bool {
+	pkgs := make(map[string]bool)
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return filepath.SkipDir
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+
+		name := fi.Name()
+		if p != dir && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+			return filepath.SkipDir
+		}
+
+		if _, err := build.ImportDir(p, 0); err != nil {
+			if _, ok := err.(*build.NoGoError); ok {
+				return nil
+			}
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return nil
+		}
+		pkgs[path.Join(root, filepath.ToSlash(rel))] = true
+		return nil
+	})
+
+	return pkgs
+}
+
+// isIgnorePattern reports whether s is a pattern for --ignore, rather than a
+// plain import path.
+func isIgnorePattern(s string) bool {
+	if strings.ContainsAny(s, "*?[") {
+		return true
+	}
+	for _, seg := range strings.Split(s, "/") {
+		if seg == "..." {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIgnorePattern returns an error if pattern is malformed.
+func checkIgnorePattern(pattern string) error {
+	for _, seg := range strings.Split(pattern, "/") {
+		if seg == "**" || seg == "..." {
+			continue
+		}
+		if _, err := path.Match(seg, ""); err != nil {
+			return fmt.Errorf("Bad --ignore pattern %q: %s", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchIgnore reports whether the import path ip matches pattern. Each
+// slash-separated element of the pattern is matched against one element of
+// the path, as by path.Match, except that an element that is ** or ... matches
+// any number of elements, including none; so github.com/foo/bar/... matches
+// github.com/foo/bar itself, and everything below it.
+func matchIgnore(pattern, ip string) bool {
+	return matchSegs(strings.Split(pattern, "/"), strings.Split(ip, "/"))
+}
+
+func matchSegs(pat, segs []string) bool {
+	if len(pat) == 0 {
+		return len(segs) == 0
+	}
+	if pat[0] == "**" || pat[0] == "..." {
+		for k := 0; k <= len(segs); k++ {
+			if matchSegs(pat[1:], segs[k:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segs) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pat[0], segs[0]); !ok {
+		return false
+	}
+	return matchSegs(pat[1:], segs[1:])
+}
+
+// expandIgnores resolves any patterns in ignore to the import paths in pkgs
+// that match them, as the solver only takes concrete paths. Plain import paths
+// are kept as they are, whether they're in pkgs or not. The patterns that
+// matched nothing are returned, too.
+func expandIgnores(ignore []string, pkgs map[string]bool) (ig, unmatched []string) {
+	// Sorted, so that the result doesn't vary from run to run
+	all := make([]string, 0, len(pkgs))
+	for ip := range pkgs {
+		all = append(all, ip)
+	}
+	sort.Strings(all)
+
+	seen := make(map[string]bool)
+	for _, s := range ignore {
+		if !isIgnorePattern(s) {
+			if !seen[s] {
+				seen[s] = true
+				ig = append(ig, s)
+			}
+			continue
+		}
+
+		var matched bool
+		for _, ip := range all {
+			if matchIgnore(s, ip) {
+				matched = true
+				if !seen[ip] {
+					seen[ip] = true
+					ig = append(ig, ip)
+				}
+			}
+		}
+		if !matched {
+			unmatched = append(unmatched, s)
+		}
+	}
+	return ig, unmatched
+}
+
+// importRoot derives the import path of dir from the GOPATH entry whose src
+// directory contains it. gopath may have multiple entries, separated as per
+// os.PathListSeparator.
+//
+// It's an error for dir not to be under any of them; carrying on with an
+// absolute path as the import root would only lead to opaque failures later,
+// in the solver.
+func importRoot(dir, gopath string) (string, error) {
+	for _, gp := range filepath.SplitList(gopath) {
+		if gp == "" {
+			continue
+		}
+
+		srcprefix := filepath.Join(gp, "src") + string(filepath.Separator)
+		if strings.HasPrefix(dir, srcprefix) {
+			return filepath.ToSlash(strings.TrimPrefix(dir, srcprefix)), nil
+		}
+	}
+
+	return "", fmt.Errorf("%s is not inside a GOPATH; gta must be run from the root of a project checked out under one of the src directories of your GOPATH (currently %q), so that the project's import path can be determined (or give it with --import-root)", dir, gopath)
+}
+
+// runGOPATH returns the GOPATH to find the project in: the one given with
+// --gopath, or else the ambient one. One given explicitly is passed on to the
+// --run command, too, so it's put in the environment returned for it, ahead
+// of --env, so that can still override it.
+func runGOPATH() (string, stringArray) {
+	if gopath == "" {
+		return build.Default.GOPATH, env
+	}
+	return gopath, append(stringArray{"GOPATH=" + gopath}, env...)
+}
+
+// checkWritable ensures that dir exists, creating it if necessary, and that
+// files can be created within it.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, ".gta-check")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// restoreVendor replaces the vendor dir in dir with the backup in _origvendor.
+func restoreVendor(dir string) error {
+	vpath := filepath.Join(dir, "vendor")
+	if err := os.RemoveAll(vpath); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(dir, "_origvendor"), vpath)
+}
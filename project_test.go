@@ -0,0 +1,99 @@
+package main
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportRoot(t *testing.T) {
+	first := filepath.FromSlash("/home/me/go")
+	second := filepath.FromSlash("/work/gopath")
+	gopath := strings.Join([]string{first, second}, string(os.PathListSeparator))
+
+	tests := []struct {
+		dir, gopath, want string
+	}{
+		{filepath.Join(first, "src", "github.com", "foo", "bar"), first, "github.com/foo/bar"},
+		{filepath.Join(first, "src", "github.com", "foo", "bar"), gopath, "github.com/foo/bar"},
+		// Under the second entry, not the first
+		{filepath.Join(second, "src", "example.com", "proj"), gopath, "example.com/proj"},
+		// Empty entries are skipped
+		{filepath.Join(second, "src", "example.com", "proj"), string(os.PathListSeparator) + second, "example.com/proj"},
+	}
+
+	for _, tt := range tests {
+		got, err := importRoot(tt.dir, tt.gopath)
+		if err != nil {
+			t.Errorf("importRoot(%q, %q) failed: %s", tt.dir, tt.gopath, err)
+		} else if got != tt.want {
+			t.Errorf("importRoot(%q, %q) = %q; want %q", tt.dir, tt.gopath, got, tt.want)
+		}
+	}
+}
+
+func TestImportRootOutsideGOPATH(t *testing.T) {
+	gopath := strings.Join([]string{
+		filepath.FromSlash("/home/me/go"),
+		filepath.FromSlash("/work/gopath"),
+	}, string(os.PathListSeparator))
+
+	for _, dir := range []string{
+		filepath.FromSlash("/tmp/proj"),
+		// In a GOPATH entry, but not under its src dir
+		filepath.FromSlash("/work/gopath/pkg/proj"),
+		// A prefix of an entry's src dir, but not inside it
+		filepath.FromSlash("/home/me/go/srcish/proj"),
+	} {
+		if got, err := importRoot(dir, gopath); err == nil {
+			t.Errorf("importRoot(%q, %q) = %q; expected an error", dir, gopath, got)
+		}
+	}
+}
+
+func TestRunGOPATH(t *testing.T) {
+	defer func(gp string, e stringArray) { gopath, env = gp, e }(gopath, env)
+
+	tmp, err := ioutil.TempDir("", "gta-gopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "src", "example.com", "proj")
+	if err = os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	env = stringArray{"FOO=bar"}
+	gopath = ""
+	gp, renv := runGOPATH()
+	if gp != build.Default.GOPATH {
+		t.Errorf("without --gopath, got GOPATH %q; want the ambient %q", gp, build.Default.GOPATH)
+	}
+	if len(renv) != 1 || renv[0] != "FOO=bar" {
+		t.Errorf("without --gopath, got --run env %q; want just what --env gave", renv)
+	}
+
+	gopath = strings.Join([]string{filepath.FromSlash("/elsewhere"), tmp}, string(os.PathListSeparator))
+	gp, renv = runGOPATH()
+	if gp != gopath {
+		t.Errorf("with --gopath, got GOPATH %q; want %q", gp, gopath)
+	}
+	if len(renv) != 2 || renv[0] != "GOPATH="+gopath || renv[1] != "FOO=bar" {
+		t.Errorf("with --gopath, got --run env %q; want GOPATH, then what --env gave", renv)
+	}
+	if len(env) != 1 {
+		t.Errorf("--env was changed to %q", env)
+	}
+
+	ir, err := importRoot(dir, gp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ir != "example.com/proj" {
+		t.Errorf("got import root %q under --gopath; want example.com/proj", ir)
+	}
+}
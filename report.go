@@ -0,0 +1,543 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sdboyer/gps"
+	"github.com/sdboyer/gta/sweep"
+	"gopkg.in/yaml.v2"
+)
+
+type jsonReport struct {
+	Roots    []gps.ProjectRoot `json:"roots"`
+	Versions []string          `json:"versions"`
+	Results  []jsonResult      `json:"results"`
+	// Set, to the reason, if nothing was checked, because --changed-only
+	// found nothing that changed
+	Skipped string `json:"skipped,omitempty"`
+}
+
+type jsonResult struct {
+	Version string `json:"version"`
+	Solved  bool   `json:"solved"`
+	// Where it failed: solve, write, pre-run, or run, or none if it didn't
+	FailureStage string `json:"failure_stage"`
+	SolveError   string `json:"solve_error,omitempty"`
+	// Only present if the solve error could be broken down
+	SolveFailure *jsonSolveFailure `json:"solve_failure,omitempty"`
+	// Only present if a run command was given, and it was actually run
+	RunExitCode *int    `json:"run_exit_code,omitempty"`
+	RunOutput   *string `json:"run_output,omitempty"`
+	// Set if the run could not be performed, or didn't produce an exit code
+	RunError string `json:"run_error,omitempty"`
+	// Set if the version failed to solve, but was run anyway, against the
+	// lock's tree, with --run-on-solve-failure
+	RanUnsolved bool `json:"ran_unsolved,omitempty"`
+	// Set if the --post-run command failed, which doesn't fail the version
+	PostRunError string `json:"post_run_error,omitempty"`
+	// Set if the vendor tree couldn't be kept with --keep-vendor
+	KeepError string `json:"keep_error,omitempty"`
+
+	// Time spent in the solver, and running the commands, in seconds
+	SolveSeconds float64  `json:"solve_seconds"`
+	RunSeconds   *float64 `json:"run_seconds,omitempty"`
+}
+
+type jsonSolveFailure struct {
+	Project  gps.ProjectRoot `json:"project"`
+	Rejected []jsonRejected  `json:"rejected"`
+}
+
+type jsonRejected struct {
+	Version   string         `json:"version"`
+	Reason    string         `json:"reason"`
+	Conflicts []jsonConflict `json:"conflicts,omitempty"`
+}
+
+type jsonConflict struct {
+	Dep          string `json:"dep"`
+	Depender     string `json:"depender"`
+	Constraint   string `json:"constraint"`
+	ExistingFrom string `json:"existing_from"`
+	Existing     string `json:"existing"`
+}
+
+func toJSONFailure(f *sweep.SolveFailure) *jsonSolveFailure {
+	jf := &jsonSolveFailure{
+		Project:  f.Project,
+		Rejected: make([]jsonRejected, len(f.Rejected)),
+	}
+	for k, rv := range f.Rejected {
+		jr := jsonRejected{
+			Version: rv.Version,
+			Reason:  rv.Reason,
+		}
+		for _, c := range rv.Conflicts {
+			jr.Conflicts = append(jr.Conflicts, jsonConflict(c))
+		}
+		jf.Rejected[k] = jr
+	}
+	return jf
+}
+
+// batchLine is the JSON object written for each line of input with --batch:
+// the report of its sweep, or the error that kept it from being checked.
+type batchLine struct {
+	Dependency string `json:"dependency"`
+	Constraint string `json:"constraint,omitempty"`
+	*jsonReport
+	Error string `json:"error,omitempty"`
+}
+
+// writeJSON writes a JSON document describing all the results to w.
+func writeJSON(w io.Writer, targets []sweep.Target, results []sweep.Result) error {
+	return encodeJSON(w, newJSONReport(targets, results))
+}
+
+// writeJSONFile writes a JSON report to the file at path, for --summary-json,
+// just as --format json would write it to stdout.
+func writeJSONFile(path string, rep *jsonReport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = encodeJSON(f, rep); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func encodeJSON(w io.Writer, rep *jsonReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
+
+// newJSONReport puts the results in their JSON form.
+func newJSONReport(targets []sweep.Target, results []sweep.Result) *jsonReport {
+	rep := &jsonReport{
+		Roots:    make([]gps.ProjectRoot, len(targets)),
+		Versions: make([]string, len(results)),
+		Results:  make([]jsonResult, len(results)),
+	}
+
+	for k, t := range targets {
+		rep.Roots[k] = t.Root
+	}
+
+	for k, r := range results {
+		rep.Versions[k] = r.Combo.Label()
+
+		res := jsonResult{
+			Version:      r.Combo.Label(),
+			Solved:       r.SolveErr == nil,
+			FailureStage: "none",
+			SolveSeconds: r.SolveDuration.Seconds(),
+		}
+		if r.Ran {
+			secs := r.RunDuration.Seconds()
+			res.RunSeconds = &secs
+		}
+
+		switch {
+		case r.SolveErr != nil:
+			res.FailureStage = "solve"
+			res.SolveError = r.SolveErr.Error()
+			if f := sweep.ParseSolveError(r.SolveErr); f != nil {
+				res.SolveFailure = toJSONFailure(f)
+			}
+			if r.Ran {
+				res.RanUnsolved = true
+				code, err := exitCode(r.RunErr)
+				if err != nil {
+					res.RunError = err.Error()
+				} else {
+					res.RunExitCode = &code
+				}
+				out := string(r.Output)
+				res.RunOutput = &out
+			}
+		case r.WriteErr != nil:
+			res.FailureStage = "write"
+			res.RunError = fmt.Sprintf("could not write tree: %s", r.WriteErr)
+		case r.PreRunErr() != nil:
+			res.FailureStage = "pre-run"
+			res.RunError = fmt.Sprintf("pre-run command failed with %s", r.PreRunErr())
+			out := string(r.PreRun.Output)
+			res.RunOutput = &out
+		case r.Ran:
+			if r.RunErr != nil {
+				res.FailureStage = "run"
+			}
+			code, err := exitCode(r.RunErr)
+			if err != nil {
+				res.RunError = err.Error()
+			} else {
+				res.RunExitCode = &code
+			}
+			out := string(r.Output)
+			res.RunOutput = &out
+		case r.RunErr != nil:
+			// The commands couldn't even be put together
+			res.FailureStage = "run"
+			res.RunError = r.RunErr.Error()
+		}
+
+		if err := r.PostRunErr(); err != nil {
+			res.PostRunError = err.Error()
+		}
+		if r.KeepErr != nil {
+			res.KeepError = r.KeepErr.Error()
+		}
+		rep.Results[k] = res
+	}
+	return rep
+}
+
+// writeSkippedJSON writes a JSON document for a sweep that was skipped as a
+// whole, with --changed-only, to w. It has no results, and says why.
+func writeSkippedJSON(w io.Writer, roots []gps.ProjectRoot, reason string) error {
+	return encodeJSON(w, skippedJSONReport(roots, reason))
+}
+
+// skippedJSONReport is the JSON form of a skipped sweep.
+func skippedJSONReport(roots []gps.ProjectRoot, reason string) *jsonReport {
+	return &jsonReport{
+		Roots:    roots,
+		Versions: []string{},
+		Results:  []jsonResult{},
+		Skipped:  reason,
+	}
+}
+
+// tapDiagnostic is the YAML diagnostic block that follows a failed test point
+// in a TAP stream.
+type tapDiagnostic struct {
+	Message string `yaml:"message"`
+	Stage   string `yaml:"stage"`
+	Command string `yaml:"command,omitempty"`
+	Output  string `yaml:"output,omitempty"`
+}
+
+// writeTAP writes a TAP version 13 stream to w, with one test point per
+// version (or combination of versions) that was checked.
+func writeTAP(w io.Writer, results []sweep.Result, runs []string) error {
+	fmt.Fprintln(w, "TAP version 13")
+	fmt.Fprintf(w, "1..%v\n", len(results))
+
+	for k, r := range results {
+		var diag *tapDiagnostic
+		switch {
+		case r.SolveErr != nil:
+			fmt.Fprintf(w, "not ok %v - %s\n", k+1, r.Combo.Label())
+			diag = &tapDiagnostic{
+				Message: "no solution could be found" + ranUnsolved(r),
+				Stage:   "solve",
+				Output:  r.SolveErr.Error(),
+			}
+		case r.WriteErr != nil:
+			fmt.Fprintf(w, "not ok %v - %s\n", k+1, r.Combo.Label())
+			diag = &tapDiagnostic{
+				Message: "could not write tree",
+				Stage:   "write",
+				Output:  r.WriteErr.Error(),
+			}
+		case r.PreRunErr() != nil:
+			fmt.Fprintf(w, "not ok %v - %s\n", k+1, r.Combo.Label())
+			diag = &tapDiagnostic{
+				Message: fmt.Sprintf("pre-run command failed with %s", r.PreRunErr()),
+				Stage:   "pre-run",
+				Command: preRun,
+				Output:  string(r.PreRun.Output),
+			}
+		case r.RunErr != nil:
+			fmt.Fprintf(w, "not ok %v - %s\n", k+1, r.Combo.Label())
+			diag = &tapDiagnostic{
+				Message: fmt.Sprintf("failed with %s", r.RunErr),
+				Stage:   "run",
+				Command: failedRun(runs, r),
+				Output:  string(r.Output),
+			}
+		default:
+			fmt.Fprintf(w, "ok %v - %s\n", k+1, r.Combo.Label())
+		}
+
+		if diag == nil {
+			continue
+		}
+		out, err := yaml.Marshal(diag)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "  ---")
+		for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+		if _, err = fmt.Fprintln(w, "  ..."); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSkippedTAP writes a TAP stream for a sweep that was skipped as a whole,
+// which has no test points, to w.
+func writeSkippedTAP(w io.Writer, reason string) error {
+	fmt.Fprintln(w, "TAP version 13")
+	_, err := fmt.Fprintf(w, "1..0 # SKIP %s\n", oneLine(reason))
+	return err
+}
+
+// ranUnsolved notes the outcome of a run made against the lock's tree, with
+// --run-on-solve-failure, for a version that failed to solve.
+func ranUnsolved(r sweep.Result) string {
+	switch {
+	case !r.Ran:
+		return ""
+	case r.RunErr != nil:
+		return fmt.Sprintf("; ran against the locked tree despite that, and failed with %s", r.RunErr)
+	}
+	return "; ran against the locked tree despite that, and passed"
+}
+
+// oneLine collapses s onto a single line, for places where a newline would
+// break the format being written.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// writeLogs writes the combined run output of each result whose commands were
+// run into dir, as <version>.log, and returns the paths written, keyed by the
+// results' combos.
+func writeLogs(dir string, results []sweep.Result) (map[string]string, error) {
+	paths := make(map[string]string)
+	used := make(map[string]bool)
+	for _, r := range results {
+		if !r.Ran {
+			continue
+		}
+
+		name := r.Combo.Filename()
+		try := name
+		for i := 2; used[try]; i++ {
+			try = fmt.Sprintf("%s-%v", name, i)
+		}
+		used[try] = true
+
+		p := filepath.Join(dir, try+".log")
+		if err := ioutil.WriteFile(p, r.Output, 0666); err != nil {
+			return paths, err
+		}
+		paths[r.Combo.String()] = p
+	}
+	return paths, nil
+}
+
+// printSummary writes a table with the status of each result to w, followed
+// by a tally of them.
+func printSummary(w io.Writer, results []sweep.Result) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tSTATUS\tDURATION")
+
+	for _, r := range results {
+		st := r.Status()
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Combo.Label(), paintStatus(st, strings.ToUpper(st.String())), r.Duration.Round(time.Millisecond))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return printTally(w, results)
+}
+
+// printTally writes a line to w with the number of results of each status.
+func printTally(w io.Writer, results []sweep.Result) error {
+	tally := make(map[sweep.Status]int)
+	for _, r := range results {
+		tally[r.Status()]++
+	}
+
+	_, err := fmt.Fprintf(w, "%s, %s, %s\n",
+		paintStatus(sweep.StatusPass, fmt.Sprintf("%v passed", tally[sweep.StatusPass])),
+		paintStatus(sweep.StatusFail, fmt.Sprintf("%v failed", tally[sweep.StatusFail])),
+		paintStatus(sweep.StatusError, fmt.Sprintf("%v could not be checked", tally[sweep.StatusError])))
+	return err
+}
+
+// printTiming writes a line to w with the total time taken, and which result
+// took the longest.
+func printTiming(w io.Writer, total time.Duration, results []sweep.Result) {
+	if len(results) == 0 {
+		return
+	}
+
+	slowest := results[0]
+	for _, r := range results[1:] {
+		if r.Duration > slowest.Duration {
+			slowest = r
+		}
+	}
+	fmt.Fprintf(w, "Took %s in all; the slowest was %s, at %s (%s solving", total.Round(time.Millisecond), slowest.Combo, slowest.Duration.Round(time.Millisecond), slowest.SolveDuration.Round(time.Millisecond))
+	if slowest.Ran {
+		fmt.Fprintf(w, ", %s running", slowest.RunDuration.Round(time.Millisecond))
+	}
+	fmt.Fprintln(w, ")")
+}
+
+// exitCode extracts the exit code from the error returned by running a
+// command. If the error didn't come from the command exiting, it's returned.
+func exitCode(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+
+	if ee, ok := err.(*exec.ExitError); ok {
+		if ws, ok := ee.Sys().(syscall.WaitStatus); ok {
+			return ws.ExitStatus(), nil
+		}
+	}
+	return 0, err
+}
+
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     float64     `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name       string          `xml:"name,attr"`
+	Time       float64         `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitMessage   `xml:"failure,omitempty"`
+	Error      *junitMessage   `xml:"error,omitempty"`
+	Skipped    *junitMessage   `xml:"skipped,omitempty"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// writeJUnit writes a JUnit XML report to the file at path, with one test case
+// per version (or combination of versions) that was checked.
+func writeJUnit(path string, targets []sweep.Target, results []sweep.Result, runs []string) error {
+	roots := make([]string, len(targets))
+	for k, t := range targets {
+		roots[k] = string(t.Root)
+	}
+
+	suite := junitSuite{
+		Name:  strings.Join(roots, ", "),
+		Tests: len(results),
+		Cases: make([]junitCase, len(results)),
+	}
+
+	for k, r := range results {
+		tc := junitCase{
+			Name: r.Combo.Label(),
+			Time: r.Duration.Seconds(),
+			Properties: []junitProperty{
+				{Name: "solve_time", Value: fmt.Sprintf("%.3f", r.SolveDuration.Seconds())},
+			},
+		}
+		if r.Ran {
+			tc.Properties = append(tc.Properties, junitProperty{Name: "run_time", Value: fmt.Sprintf("%.3f", r.RunDuration.Seconds())})
+		}
+		suite.Time += tc.Time
+
+		switch {
+		case r.SolveErr != nil:
+			tc.Failure = &junitMessage{
+				Message: "no solution could be found" + ranUnsolved(r),
+				Body:    r.SolveErr.Error(),
+			}
+		case r.WriteErr != nil:
+			tc.Error = &junitMessage{
+				Message: fmt.Sprintf("could not write tree: %s", r.WriteErr),
+			}
+		case r.PreRunErr() != nil:
+			tc.Error = &junitMessage{
+				Message: fmt.Sprintf("pre-run command failed with %s", r.PreRunErr()),
+				Body:    string(r.PreRun.Output),
+			}
+		case r.RunErr != nil:
+			tc.Failure = &junitMessage{
+				Message: fmt.Sprintf("`%s` failed with %s", failedRun(runs, r), r.RunErr),
+				Body:    string(r.Output),
+			}
+		}
+
+		if tc.Failure != nil {
+			suite.Failures++
+		}
+		if tc.Error != nil {
+			suite.Errors++
+		}
+		if tc.Skipped != nil {
+			suite.Skipped++
+		}
+		suite.Cases[k] = tc
+	}
+
+	return writeJUnitSuite(path, suite)
+}
+
+// writeJUnitSuite writes suite out, as XML, to the file at path.
+func writeJUnitSuite(path string, suite junitSuite) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = io.WriteString(f, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	if err = enc.Encode(suite); err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, "\n")
+	return err
+}
+
+// writeSkippedJUnit writes a JUnit XML report for a sweep that was skipped as a
+// whole to the file at path, as a single skipped test case.
+func writeSkippedJUnit(path string, roots []gps.ProjectRoot, reason string) error {
+	names := make([]string, len(roots))
+	for k, r := range roots {
+		names[k] = string(r)
+	}
+	return writeJUnitSuite(path, junitSuite{
+		Name:    strings.Join(names, ", "),
+		Tests:   1,
+		Skipped: 1,
+		Cases: []junitCase{{
+			Name:    "changed-only",
+			Skipped: &junitMessage{Message: reason},
+		}},
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sdboyer/gps"
+	"github.com/sdboyer/gta/sweep"
+)
+
+// reportResults has one result for each stage that can fail, and one that
+// passed.
+func reportResults() []sweep.Result {
+	combo := func(v string) sweep.Combo {
+		return sweep.Combo{{Root: "github.com/foo/bar", Version: gps.NewVersion(v)}}
+	}
+	return []sweep.Result{
+		{Combo: combo("v1.0.0"), SolveErr: errors.New("no solution")},
+		{Combo: combo("v1.1.0"), WriteErr: errors.New("disk full")},
+		{Combo: combo("v1.2.0"), PreRun: &sweep.CommandResult{Argv: []string{"false"}, Err: errors.New("exit status 1")}},
+		{Combo: combo("v1.3.0"), Ran: true, RunErr: errors.New("exit status 1")},
+		{Combo: combo("v1.4.0"), Ran: true},
+	}
+}
+
+func TestTAPReportsEveryFailure(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeTAP(&buf, reportResults(), []string{"go test"}); err != nil {
+		t.Fatal(err)
+	}
+	var nok, notok int
+	for _, line := range strings.Split(buf.String(), "\n") {
+		switch {
+		case strings.HasPrefix(line, "not ok "):
+			notok++
+		case strings.HasPrefix(line, "ok "):
+			nok++
+			if strings.Contains(line, "# SKIP") {
+				t.Errorf("%q is a skip, which TAP consumers don't count as a failure", line)
+			}
+		}
+	}
+	if notok != 4 || nok != 1 {
+		t.Errorf("TAP has %v failures and %v passes; want 4 and 1, as the exit status has it:\n%s", notok, nok, buf.String())
+	}
+}
+
+func TestJUnitReportsEveryFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gta-junit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "report.xml")
+
+	targets := []sweep.Target{{Root: "github.com/foo/bar"}}
+	if err = writeJUnit(path, targets, reportResults(), []string{"go test"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suite junitSuite
+	if err = xml.Unmarshal(b, &suite); err != nil {
+		t.Fatal(err)
+	}
+	if suite.Failures != 2 || suite.Errors != 2 || suite.Skipped != 0 {
+		t.Errorf("JUnit has %v failures, %v errors and %v skipped; want 2, 2 and 0:\n%s", suite.Failures, suite.Errors, suite.Skipped, b)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sdboyer/gps"
+	"github.com/sdboyer/gta/sweep"
+	"github.com/spf13/pflag"
+)
+
+// cmdFlags are the flags gta was run with, from which repro lines are made.
+var cmdFlags *pflag.FlagSet
+
+// reproDropped are the flags that aren't carried over into a repro line:
+// those that choose which versions to check, since it checks just the one,
+// and those that would write reports over the sweep's own, or only shape
+// the output of a whole sweep.
+var reproDropped = map[string]bool{
+	"versions": true, "revisions": true, "semver": true, "branch": true,
+	"version": true, "all-branches": true, "max-versions": true,
+	"exclude-versions": true, "include-prerelease": true, "lock-as-floor": true,
+	"max-combos": true, "bisect": true, "batch": true, "changed-only": true,
+	"changed-file": true, "confirm": true, "yes": true, "list-only": true,
+	"fail-fast": true, "junit": true, "summary-json": true, "log-dir": true,
+	"keep-vendor": true, "format": true, "sort-by": true, "summary-only": true,
+	"shapes": true, "force-restore": true, "version-override": true,
+}
+
+// reproLine returns a gta command line that checks just the combo c again,
+// with the same flags as this one had (apart from those in reproDropped), for
+// someone to copy and paste. Its first target is given with --versions, or
+// --revisions for a bare revision; any others are held at their versions
+// with overrides. given maps each target's root to the argument it was given
+// as. It returns "" if c can't be put on a command line: a target other than
+// the first is at a bare revision, or is in fixed, as an --override for it
+// would lose where it's to come from.
+func reproLine(flags *pflag.FlagSet, c sweep.Combo, given map[gps.ProjectRoot]string, fixed map[gps.ProjectRoot]bool) string {
+	first := c[0]
+	line := []string{"gta"}
+	flags.Visit(func(f *pflag.Flag) {
+		if reproDropped[f.Name] {
+			return
+		}
+		switch f.Value.Type() {
+		case "stringArray":
+			for _, v := range *f.Value.(*stringArray) {
+				line = append(line, "--"+f.Name, v)
+			}
+		case "stringSlice":
+			line = append(line, "--"+f.Name, strings.Trim(f.Value.String(), "[]"))
+		case "bool":
+			if f.Value.String() == "true" {
+				line = append(line, "--"+f.Name)
+			} else {
+				line = append(line, "--"+f.Name+"=false")
+			}
+		default:
+			line = append(line, "--"+f.Name, f.Value.String())
+		}
+	})
+
+	// Only the overrides for these very versions still apply
+	for _, o := range versionOverrides {
+		if v, _, _, err := parseVersionOverride(o); err == nil && reproHas(c, v) {
+			line = append(line, "--version-override", o)
+		}
+	}
+
+	for _, av := range c[1:] {
+		if fixed[av.Root] {
+			return ""
+		}
+		var cs string
+		switch v := av.Version.(type) {
+		case gps.Revision:
+			return ""
+		case gps.PairedVersion:
+			cs = reproConstraint(v.Unpair())
+		case gps.UnpairedVersion:
+			cs = reproConstraint(v)
+		}
+		line = append(line, "--override", fmt.Sprintf("%s@%s", av.Root, cs))
+	}
+
+	if _, ok := first.Version.(gps.Revision); ok {
+		line = append(line, "--revisions", first.Version.String())
+	} else {
+		line = append(line, "--versions", first.Version.String())
+	}
+	line = append(line, given[first.Root])
+
+	for k, s := range line {
+		line[k] = shellQuote(s)
+	}
+	return strings.Join(line, " ")
+}
+
+// reproConstraint gives the version v as a constraint for --override that
+// matches exactly it.
+func reproConstraint(v gps.UnpairedVersion) string {
+	switch v.Type() {
+	case "branch":
+		return "branch=" + v.String()
+	case "semver":
+		return v.String()
+	}
+	return "version=" + v.String()
+}
+
+func reproHas(c sweep.Combo, v string) bool {
+	for _, av := range c {
+		if av.Version.String() == v {
+			return true
+		}
+	}
+	return false
+}
+
+// shellSafe matches the strings that a POSIX shell takes literally, unquoted.
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes s for a POSIX shell, if it needs it.
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/sdboyer/gta/sweep"
+)
+
+// splitCommand splits a command string into an argv, honoring shell-style
+// quoting: single quotes preserve everything literally, double quotes allow
+// backslash-escaping of the characters that are special within them, and a
+// backslash outside of quotes escapes the next character.
+//
+// No other shell features (expansion, pipes, etc.) are supported; the result is
+// intended to be passed directly to exec.Command.
+func splitCommand(s string) ([]string, error) {
+	var argv []string
+	var cur bytes.Buffer
+	// Whether a word is in progress; distinguishes "" from no word at all
+	var inWord bool
+
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '\\':
+			if i+1 == len(rs) {
+				return nil, fmt.Errorf("trailing backslash in command %q", s)
+			}
+			i++
+			cur.WriteRune(rs[i])
+			inWord = true
+		case r == '\'':
+			end := i + 1
+			for end < len(rs) && rs[end] != '\'' {
+				end++
+			}
+			if end == len(rs) {
+				return nil, fmt.Errorf("unterminated single quote in command %q", s)
+			}
+			cur.WriteString(string(rs[i+1 : end]))
+			i = end
+			inWord = true
+		case r == '"':
+			i++
+			for ; i < len(rs) && rs[i] != '"'; i++ {
+				if rs[i] == '\\' && i+1 < len(rs) {
+					switch rs[i+1] {
+					case '\\', '"', '$', '`', '\n':
+						i++
+					}
+				}
+				cur.WriteRune(rs[i])
+			}
+			if i == len(rs) {
+				return nil, fmt.Errorf("unterminated double quote in command %q", s)
+			}
+			inWord = true
+		case unicode.IsSpace(r):
+			if inWord {
+				argv = append(argv, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		argv = append(argv, cur.String())
+	}
+	return argv, nil
+}
+
+// runVars are the values available to a templated --run command.
+type runVars struct {
+	// The root and version of the first (usually the only) dep being checked
+	Root, Version string
+	// The version of every dep being checked, keyed by root
+	Versions map[string]string
+}
+
+// expandCommand expands the --run template for the combo, and splits the
+// result into an argv.
+func expandCommand(t *template.Template, c sweep.Combo) ([]string, error) {
+	vars := runVars{
+		Root:     string(c[0].Root),
+		Version:  c[0].Version.String(),
+		Versions: make(map[string]string, len(c)),
+	}
+	for _, av := range c {
+		vars.Versions[string(av.Root)] = av.Version.String()
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, vars); err != nil {
+		return nil, err
+	}
+	return splitCommand(buf.String())
+}
+
+// failedRun returns the --run command that failed for the result. If none of
+// them were even run, they're all named.
+func failedRun(runs []string, r sweep.Result) string {
+	if k := len(r.Commands) - 1; k >= 0 && k < len(runs) {
+		return runs[k]
+	}
+	return strings.Join(runs, "`, then `")
+}
+
+// withBuildTags returns the GOFLAGS for the --run commands with -tags for
+// tags, which may be separated by commas or spaces, as for go build. They're
+// merged into whatever GOFLAGS the commands would otherwise get: that of env,
+// if it sets one, or else that of this process. Tags already given there with
+// -tags are kept, as the go tool would only take either one -tags or the
+// other.
+func withBuildTags(env []string, tags string) string {
+	flags := os.Getenv("GOFLAGS")
+	for _, e := range env {
+		if strings.HasPrefix(e, "GOFLAGS=") {
+			flags = strings.TrimPrefix(e, "GOFLAGS=")
+		}
+	}
+
+	// GOFLAGS is split on spaces, so the tags can only be comma-separated
+	all := strings.FieldsFunc(tags, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+	var kept []string
+	for _, f := range strings.Fields(flags) {
+		if t := strings.TrimLeft(f, "-"); strings.HasPrefix(t, "tags=") && len(f)-len(t) <= 2 {
+			all = append(strings.Split(strings.TrimPrefix(t, "tags="), ","), all...)
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return strings.Join(append(kept, "-tags="+strings.Join(all, ",")), " ")
+}
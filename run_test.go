@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"go test ./...", []string{"go", "test", "./..."}},
+		{"  go   test  ", []string{"go", "test"}},
+		{"", nil},
+		{"go test -run 'TestFoo Bar'", []string{"go", "test", "-run", "TestFoo Bar"}},
+		{`go test -run "TestFoo Bar"`, []string{"go", "test", "-run", "TestFoo Bar"}},
+		{`echo 'a  b'`, []string{"echo", "a  b"}},
+		{`echo "a  b"`, []string{"echo", "a  b"}},
+		// Nothing is special within single quotes
+		{`echo 'a\"b $c'`, []string{"echo", `a\"b $c`}},
+		// Within double quotes, a backslash only escapes what's special there
+		{`echo "a\"b \$c \d"`, []string{"echo", `a"b $c \d`}},
+		{`echo "it's"`, []string{"echo", "it's"}},
+		{`echo a\ b`, []string{"echo", "a b"}},
+		{`echo \'a\'`, []string{"echo", "'a'"}},
+		{`echo ""`, []string{"echo", ""}},
+		{`echo '' x`, []string{"echo", "", "x"}},
+		{`echo a"b c"'d e'f`, []string{"echo", "ab cd ef"}},
+		{`-ldflags="-X main.v=1"`, []string{"-ldflags=-X main.v=1"}},
+	}
+
+	for _, tt := range tests {
+		got, err := splitCommand(tt.in)
+		if err != nil {
+			t.Errorf("splitCommand(%q) failed: %s", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommand(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSplitCommandErrors(t *testing.T) {
+	for _, in := range []string{
+		`echo 'a`,
+		`echo "a`,
+		`echo "a\"`,
+		`echo a\`,
+	} {
+		if got, err := splitCommand(in); err == nil {
+			t.Errorf("splitCommand(%q) = %q; expected an error", in, got)
+		}
+	}
+}
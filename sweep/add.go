@@ -0,0 +1,52 @@
+package sweep
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/sdboyer/gps"
+)
+
+// addPkgDir is the name of the package through which the root is made to
+// import the packages in Options.Add. It mustn't start with _ or ., nor be
+// testdata, as gps leaves the imports of such packages out of the solve.
+const addPkgDir = "gtaadd"
+
+// addRoot makes a copy of the project at root, for the solver to analyze in
+// its place, with an extra package that imports each of add. gps only solves
+// for projects that the root's packages import, so this is what makes deps
+// the project doesn't yet use part of the solve. The temp dir holding the copy
+// is returned, for removal once solving is done, along with the copy itself.
+func addRoot(root string, ir gps.ProjectRoot, add []string) (tmp, dir string, err error) {
+	tmp, err = ioutil.TempDir("", "gta-add-")
+	if err != nil {
+		return "", "", err
+	}
+
+	dir = filepath.Join(tmp, "src", filepath.FromSlash(string(ir)))
+	if err = copyProject(root, dir); err != nil {
+		os.RemoveAll(tmp)
+		return "", "", err
+	}
+
+	var src bytes.Buffer
+	src.WriteString("// Written by gta, so that the solver sees the deps being added.\n\npackage gtaadd\n\nimport (\n")
+	for _, ip := range add {
+		fmt.Fprintf(&src, "\t_ %s\n", strconv.Quote(ip))
+	}
+	src.WriteString(")\n")
+
+	pdir := filepath.Join(dir, addPkgDir)
+	if err = os.MkdirAll(pdir, 0777); err == nil {
+		err = ioutil.WriteFile(filepath.Join(pdir, "add.go"), src.Bytes(), 0666)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return "", "", err
+	}
+	return tmp, dir, nil
+}
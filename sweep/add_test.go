@@ -0,0 +1,47 @@
+package sweep
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/sdboyer/gps"
+)
+
+func TestAddedDepIsSolvedFor(t *testing.T) {
+	root := newProject(t, "github.com/foo/bar")
+	defer os.RemoveAll(root)
+
+	sm := newFakeSM(map[gps.ProjectRoot][]gps.Version{
+		"github.com/foo/bar":    {gps.NewVersion("v1.0.0").Is("aaaaaaa")},
+		"github.com/foo/newdep": {gps.NewVersion("v1.0.0").Is("bbbbbbb")},
+	})
+	results, err := Check(context.Background(), Options{
+		RootDir:       root,
+		ImportRoot:    "example.com/proj",
+		SourceManager: sm,
+		Targets:       []Target{{Root: "github.com/foo/newdep"}},
+		Add:           []string{"github.com/foo/newdep"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %v results; want 1", len(results))
+	}
+	r := results[0]
+	if r.SolveErr != nil {
+		t.Fatalf("%s failed to solve: %s", r.Combo, r.SolveErr)
+	}
+
+	got := make(map[gps.ProjectRoot]bool)
+	for _, lp := range r.Solution.Projects() {
+		got[lp.Ident().ProjectRoot] = true
+	}
+	if !got["github.com/foo/newdep"] {
+		t.Errorf("the added dep isn't in the solution, which has %v", got)
+	}
+	if !got["github.com/foo/bar"] {
+		t.Errorf("the dep the project already imports isn't in the solution, which has %v", got)
+	}
+}
@@ -0,0 +1,103 @@
+package sweep
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sdboyer/gps"
+)
+
+// A Bisection is the outcome of Bisect.
+type Bisection struct {
+	// Versions are the target's versions that were bisected, in order.
+	Versions []gps.Version
+
+	// Results are those of the versions that were checked, in the order they
+	// were checked.
+	Results []Result
+
+	// LastPass and FirstFail are the adjacent versions either side of the
+	// point at which checking starts to fail. Both are nil if the versions at
+	// the two ends passed, or both failed, as there's then no such point to
+	// find.
+	LastPass, FirstFail gps.Version
+}
+
+// Bisect finds the point in the versions of a single target at which checking
+// starts to fail, by binary search, so that only about log2(n) of n versions
+// are checked, rather than all of them. Each version is checked as Check
+// would, with the same Options.
+//
+// It assumes that the versions, in the order they're given (or found in, as
+// for Check), pass up to some point, and fail from there on, or the other way
+// round; the versions at the two ends are checked first, to find out which.
+// If the results aren't like that, the point found is just one at which they
+// change, and there may be others. A version whose tree can't be written, or
+// whose PreRun fails, can't be placed either side, so it stops the bisection
+// with an error.
+func Bisect(ctx context.Context, opts Options) (*Bisection, error) {
+	if len(opts.Targets) != 1 {
+		return nil, fmt.Errorf("exactly one target must be provided to bisect")
+	}
+	sw, err := newSweeper(opts)
+	if err != nil {
+		return nil, err
+	}
+	if sw.cleanup != nil {
+		defer sw.cleanup()
+	}
+
+	t := sw.targets[0]
+	b := &Bisection{Versions: t.vl}
+	if len(t.vl) < 2 {
+		return nil, fmt.Errorf("%s has only %v version to check, and at least two are needed to bisect", t.root, len(t.vl))
+	}
+
+	// check reports whether the k'th version passed
+	check := func(k int) (bool, error) {
+		results, err := sw.checkCombos(ctx, []Combo{{{Root: t.root, Version: t.vl[k]}}})
+		b.Results = append(b.Results, results...)
+		if err != nil {
+			return false, err
+		}
+		r := results[0]
+		switch {
+		case r.WriteErr != nil:
+			return false, fmt.Errorf("%s could not be checked, as its tree could not be written: %s", r.Combo, r.WriteErr)
+		case r.PreRunErr() != nil:
+			return false, fmt.Errorf("%s could not be checked, as the pre-run command failed: %s", r.Combo, r.PreRunErr())
+		}
+		return r.Status() == StatusPass, nil
+	}
+
+	first, err := check(0)
+	if err != nil {
+		return b, err
+	}
+	last, err := check(len(t.vl) - 1)
+	if err != nil || first == last {
+		return b, err
+	}
+
+	// Narrow down from the two ends, keeping pass at a version that passed,
+	// and fail at one that failed
+	pass, fail := 0, len(t.vl)-1
+	if last {
+		pass, fail = fail, pass
+	}
+	for pass-fail > 1 || fail-pass > 1 {
+		mid := (pass + fail) / 2
+		ok, err := check(mid)
+		if err != nil {
+			return b, err
+		}
+		if ok {
+			pass = mid
+		} else {
+			fail = mid
+		}
+	}
+
+	b.LastPass, b.FirstFail = t.vl[pass], t.vl[fail]
+	return b, nil
+}
@@ -0,0 +1,80 @@
+package sweep
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sdboyer/gps"
+)
+
+// An AtVersion is a project root paired with a version of it.
+type AtVersion struct {
+	Root    gps.ProjectRoot
+	Version gps.Version
+}
+
+func (av AtVersion) String() string {
+	return fmt.Sprintf("%s@%s", av.Root, av.Version)
+}
+
+// A Combo is one combination of target versions to be checked together. It
+// has exactly one entry per Target, in the same order as the Targets.
+type Combo []AtVersion
+
+func (c Combo) String() string {
+	s := make([]string, len(c))
+	for k, av := range c {
+		s[k] = av.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// Label is a short name for the combo. When only a single dependency is being
+// checked, the root is omitted.
+func (c Combo) Label() string {
+	if len(c) == 1 {
+		return c[0].Version.String()
+	}
+	return c.String()
+}
+
+// Filename is the combo's Label, with anything that's unsafe in a file name
+// replaced by underscores.
+func (c Combo) Filename() string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, c.Label())
+}
+
+// Has reports whether the project root is one of the targets in the combo.
+func (c Combo) Has(root gps.ProjectRoot) bool {
+	for _, av := range c {
+		if av.Root == root {
+			return true
+		}
+	}
+	return false
+}
+
+// combos produces the cartesian product of all the targets' versions. The
+// ordering is such that the first target's versions vary the slowest.
+func combos(targets []target) []Combo {
+	cs := []Combo{nil}
+	for _, t := range targets {
+		next := make([]Combo, 0, len(cs)*len(t.vl))
+		for _, c := range cs {
+			for _, v := range t.vl {
+				nc := make(Combo, len(c), len(c)+1)
+				copy(nc, c)
+				next = append(next, append(nc, AtVersion{Root: t.root, Version: v}))
+			}
+		}
+		cs = next
+	}
+
+	return cs
+}
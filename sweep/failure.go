@@ -0,0 +1,126 @@
+package sweep
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/sdboyer/gps"
+)
+
+// A SolveFailure is the structure of a failed solve, as far as it can be
+// recovered from the solver's error.
+type SolveFailure struct {
+	// Project is the project for which no acceptable version could be found.
+	Project gps.ProjectRoot
+
+	// Rejected holds each version of Project that was tried, in the order
+	// they were tried, and why each was rejected.
+	Rejected []RejectedVersion
+}
+
+// A RejectedVersion is a version that the solver tried and rejected.
+type RejectedVersion struct {
+	Version string
+	Reason  string
+
+	// Conflicts is set if the version was rejected because a constraint it,
+	// or something selected alongside it, has on a dep can't be reconciled
+	// with constraints already in effect.
+	Conflicts []Conflict
+}
+
+// A Conflict is a pair of constraints on the same dep that have no versions
+// in common.
+type Conflict struct {
+	// Dep is the project on which the constraints are placed.
+	Dep string
+
+	// Depender, as project@version, introduced Constraint on Dep.
+	Depender   string
+	Constraint string
+
+	// ExistingFrom, as project@version, had already placed Existing on Dep.
+	ExistingFrom string
+	Existing     string
+}
+
+var (
+	noVersionsRe = regexp.MustCompile(`^No versions of (\S+) met constraints:$`)
+	rejectedRe   = regexp.MustCompile(`^\t(\S+): (.*)$`)
+	conflictRe   = regexp.MustCompile(`^Could not introduce (.+?), as it has a dependency on (.+?) with constraint (.+?), which has no overlap with existing constraint (.+) from (.+)$`)
+	conflictsRe  = regexp.MustCompile(`^Could not introduce (.+?), as it has a dependency on (.+?) with constraint (.+?), which (?:has no overlap with the following existing constraints|does not overlap with the intersection of existing constraints from other currently selected packages):$`)
+	siblingRe    = regexp.MustCompile(`^\t(.+?) from (.+)$`)
+)
+
+// ParseSolveError recovers the structure of an error returned from solving,
+// for the most common kind of failure: that no version of some project
+// satisfied all the constraints on it. It returns nil for any other error.
+//
+// The solver's own error types aren't exported, so this works from the text of
+// the message, which flattens them in a regular way.
+func ParseSolveError(err error) *SolveFailure {
+	if err == nil {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimRight(err.Error(), "\n"), "\n")
+	m := noVersionsRe.FindStringSubmatch(lines[0])
+	if m == nil {
+		return nil
+	}
+
+	f := &SolveFailure{Project: gps.ProjectRoot(m[1])}
+	for _, line := range lines[1:] {
+		if line == "" {
+			continue
+		}
+		// Anything else that's indented, but isn't a version and a colon, is
+		// the continuation of the previous version's reason
+		if m := rejectedRe.FindStringSubmatch(line); m != nil {
+			f.Rejected = append(f.Rejected, RejectedVersion{Version: m[1], Reason: m[2]})
+			continue
+		}
+		if len(f.Rejected) > 0 {
+			rv := &f.Rejected[len(f.Rejected)-1]
+			rv.Reason += "\n" + strings.TrimPrefix(line, "\t")
+		}
+	}
+
+	for k := range f.Rejected {
+		f.Rejected[k].Conflicts = conflicts(f.Rejected[k].Reason)
+	}
+	return f
+}
+
+// conflicts extracts the conflicting constraints from the reason a version was
+// rejected, if it was rejected for having any.
+func conflicts(reason string) []Conflict {
+	lines := strings.Split(reason, "\n")
+	if m := conflictRe.FindStringSubmatch(lines[0]); m != nil {
+		return []Conflict{{
+			Depender:     m[1],
+			Dep:          m[2],
+			Constraint:   m[3],
+			Existing:     m[4],
+			ExistingFrom: m[5],
+		}}
+	}
+
+	m := conflictsRe.FindStringSubmatch(lines[0])
+	if m == nil {
+		return nil
+	}
+	var cs []Conflict
+	for _, line := range lines[1:] {
+		if sm := siblingRe.FindStringSubmatch("\t" + line); sm != nil {
+			cs = append(cs, Conflict{
+				Depender:     m[1],
+				Dep:          m[2],
+				Constraint:   m[3],
+				Existing:     sm[1],
+				ExistingFrom: sm[2],
+			})
+		}
+	}
+	return cs
+}
@@ -0,0 +1,233 @@
+package sweep
+
+import (
+	"context"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sdboyer/gps"
+)
+
+// A workspace is a copy of the project, placed within a GOPATH entry of its
+// own, in which commands can be run without touching the original.
+type workspace struct {
+	gopath string
+	dir    string
+
+	// deps is where the trees of the project's deps are written: its vendor
+	// dir, or, for modules, a dir alongside the GOPATH entry's src
+	deps string
+}
+
+// newWorkspace copies the project at root, minus its vendor dir and VCS
+// metadata, into a new temporary GOPATH entry, at the path corresponding to
+// its import root.
+func newWorkspace(root string, ir gps.ProjectRoot, modules bool) (*workspace, error) {
+	gp, err := ioutil.TempDir("", "gta-run-")
+	if err != nil {
+		return nil, err
+	}
+
+	ws := &workspace{
+		gopath: gp,
+		dir:    filepath.Join(gp, "src", filepath.FromSlash(string(ir))),
+	}
+	ws.deps = filepath.Join(ws.dir, "vendor")
+	if modules {
+		ws.deps = filepath.Join(gp, "mod")
+	}
+	if err = copyProject(root, ws.dir); err != nil {
+		os.RemoveAll(gp)
+		return nil, err
+	}
+	return ws, nil
+}
+
+// copyProject copies the tree at from to to, skipping the top-level entries
+// that have no bearing on building the project. Symlinks are copied as
+// symlinks.
+func copyProject(from, to string) error {
+	return filepath.Walk(from, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		if filepath.Dir(rel) == "." {
+			switch fi.Name() {
+			case "vendor", "_origvendor", ".git", ".hg", ".bzr", ".svn":
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		dst := filepath.Join(to, rel)
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(dst, 0777)
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, dst)
+		case fi.Mode().IsRegular():
+			return copyFile(path, dst, fi.Mode())
+		}
+		// Sockets, devices, and the like aren't worth bringing along
+		return nil
+	})
+}
+
+func copyFile(from, to string, mode os.FileMode) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// gopath returns the GOPATH the commands would otherwise see: the last one set
+// in env, if any, or else the default.
+func gopath(env []string) string {
+	for k := len(env) - 1; k >= 0; k-- {
+		if strings.HasPrefix(env[k], "GOPATH=") {
+			return strings.TrimPrefix(env[k], "GOPATH=")
+		}
+	}
+	return build.Default.GOPATH
+}
+
+// runIsolated is runAll for when Isolate is set. Each of up to Jobs workers
+// gets a workspace of its own, and works through the solved combos, reusing
+// its tree from one combo to the next where it can. Results are reported in
+// combo order, regardless of the order in which they finish. All the
+// workspaces are removed before it returns, whatever happened in them.
+func (sw *sweeper) runIsolated(ctx context.Context, results []Result, keep string, kept map[string]bool) error {
+	var todo []int
+	for k := range results {
+		// If solving failed, no point in even checking the run, unless
+		// there's a fallback tree to run against
+		if results[k].runnable() {
+			todo = append(todo, k)
+		}
+	}
+
+	n := sw.opts.Jobs
+	if n > len(todo) {
+		n = len(todo)
+	}
+
+	var wss []*workspace
+	defer func() {
+		for _, ws := range wss {
+			os.RemoveAll(ws.gopath)
+		}
+	}()
+	for i := 0; i < n; i++ {
+		ws, err := newWorkspace(sw.opts.RootDir, sw.opts.ImportRoot, sw.opts.Modules)
+		if err != nil {
+			return fmt.Errorf("could not make a copy of the project to run in: %s", err)
+		}
+		wss = append(wss, ws)
+	}
+
+	// Each workspace's GOPATH entry comes first, so that it's where the
+	// project is found
+	gp := gopath(sw.opts.Env)
+
+	// Failing fast stops new runs from being started, and kills any in flight,
+	// just as cancellation does
+	rctx, rcancel := context.WithCancel(ctx)
+	defer rcancel()
+
+	// Guards kept, which all the workers share
+	var mu sync.Mutex
+	jobc, donec := make(chan int), make(chan int)
+	var wg sync.WaitGroup
+	for _, ws := range wss {
+		wg.Add(1)
+		go func(ws *workspace) {
+			defer wg.Done()
+			env := []string{"GOPATH=" + ws.gopath + string(os.PathListSeparator) + gp}
+			if sw.opts.Modules {
+				env = append(env, modEnv...)
+			}
+
+			// The projects in the workspace's tree, if it can be reused
+			var prev map[gps.ProjectRoot]string
+			for k := range jobc {
+				r := &results[k]
+				if rctx.Err() != nil {
+					donec <- k
+					continue
+				}
+
+				sw.runCombo(rctx, r, ws.dir, ws.deps, prev, env)
+				prev = nil
+				if r.WriteErr == nil {
+					if keep == "" {
+						prev = treeProjects(r.tree())
+					} else {
+						mu.Lock()
+						kp := keepPath(keep, r.Combo, kept)
+						mu.Unlock()
+						r.KeepErr = keepTree(ws.deps, kp)
+					}
+				}
+				donec <- k
+			}
+		}(ws)
+	}
+	go func() {
+		for _, k := range todo {
+			jobc <- k
+		}
+		close(jobc)
+	}()
+
+	// Report results as they come in, but always in combo order
+	done := make(map[int]bool, len(todo))
+	var next int
+	for range todo {
+		done[<-donec] = true
+		for ; next < len(todo) && done[todo[next]]; next++ {
+			if rctx.Err() != nil {
+				continue
+			}
+			r := results[todo[next]]
+			if sw.opts.OnRun != nil {
+				sw.opts.OnRun(r)
+			}
+			if sw.opts.FailFast && r.failed() {
+				rcancel()
+			}
+		}
+	}
+
+	// The workspaces can't be removed while any worker might still be using
+	// one
+	wg.Wait()
+	return ctx.Err()
+}
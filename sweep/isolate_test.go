@@ -0,0 +1,75 @@
+package sweep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sdboyer/gps"
+)
+
+// newProject makes a minimal Go project, which imports the given packages, in
+// a temporary dir, which the caller must remove.
+func newProject(t testing.TB, imports ...string) string {
+	dir, err := ioutil.TempDir("", "gta-test-proj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := "package main\n\n"
+	for _, ip := range imports {
+		src += fmt.Sprintf("import _ %q\n", ip)
+	}
+	src += "\nfunc main() {}\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(src), 0666); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestIsolatedGOPATH(t *testing.T) {
+	root := newProject(t)
+	defer os.RemoveAll(root)
+
+	// As from --gopath, which may have several entries
+	given := strings.Join([]string{filepath.FromSlash("/elsewhere/one"), filepath.FromSlash("/elsewhere/two")}, string(os.PathListSeparator))
+	sw := &sweeper{opts: Options{
+		RootDir:    root,
+		ImportRoot: "example.com/proj",
+		Isolate:    true,
+		Jobs:       1,
+		Env:        []string{"GOPATH=" + given},
+		// The copy of the project must be in the workspace's GOPATH entry
+		Run: [][]string{{"sh", "-c", `test -f "${GOPATH%%` + string(os.PathListSeparator) + `*}/src/example.com/proj/main.go" && printf %s "$GOPATH"`}},
+	}}
+	// Nothing to write, and no solve needed, for a fallback with no projects
+	results := []Result{{
+		Combo:    Combo{{Root: "example.com/dep", Version: gps.NewVersion("v1.0.0")}},
+		SolveErr: errors.New("no solution"),
+		Fallback: gps.SimpleLock{},
+	}}
+
+	if err := sw.runAll(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+	r := results[0]
+	if r.RunErr != nil {
+		t.Fatalf("command failed with %s, output:\n%s", r.RunErr, r.Output)
+	}
+
+	entries := filepath.SplitList(string(r.Output))
+	if len(entries) != 3 || strings.Join(entries[1:], string(os.PathListSeparator)) != given {
+		t.Fatalf("command got GOPATH %q; want a workspace entry, then %q", r.Output, given)
+	}
+	if !strings.HasPrefix(filepath.Base(entries[0]), "gta-run-") {
+		t.Errorf("first GOPATH entry %q isn't a workspace", entries[0])
+	}
+	if _, err := os.Stat(entries[0]); !os.IsNotExist(err) {
+		t.Errorf("workspace GOPATH entry %s was left behind", entries[0])
+	}
+}
@@ -0,0 +1,108 @@
+package sweep
+
+import "github.com/sdboyer/gps"
+
+type simpleRootManifest struct {
+	c   map[gps.ProjectRoot]gps.ProjectConstraint
+	tc  map[gps.ProjectRoot]gps.ProjectConstraint
+	ovr gps.ProjectConstraints
+	ig  map[string]bool
+}
+
+func (m simpleRootManifest) DependencyConstraints() []gps.ProjectConstraint {
+	ds := make([]gps.ProjectConstraint, 0)
+	for _, d := range m.c {
+		ds = append(ds, d)
+	}
+	return ds
+}
+
+func (m simpleRootManifest) TestDependencyConstraints() []gps.ProjectConstraint {
+	ds := make([]gps.ProjectConstraint, 0)
+	for _, d := range m.tc {
+		ds = append(ds, d)
+	}
+	return ds
+}
+
+func (m simpleRootManifest) Overrides() gps.ProjectConstraints {
+	return m.ovr
+}
+
+func (m simpleRootManifest) IgnorePackages() map[string]bool {
+	return m.ig
+}
+
+// clone makes a copy of the manifest that can be modified without affecting
+// the original. The maps are copied, but their values are not.
+func (m simpleRootManifest) clone() simpleRootManifest {
+	m2 := simpleRootManifest{
+		c:   make(map[gps.ProjectRoot]gps.ProjectConstraint, len(m.c)),
+		tc:  make(map[gps.ProjectRoot]gps.ProjectConstraint, len(m.tc)),
+		ovr: make(gps.ProjectConstraints, len(m.ovr)),
+	}
+
+	for pr, pc := range m.c {
+		m2.c[pr] = pc
+	}
+	for pr, pc := range m.tc {
+		m2.tc[pr] = pc
+	}
+	for pr, pp := range m.ovr {
+		m2.ovr[pr] = pp
+	}
+	if m.ig != nil {
+		m2.ig = make(map[string]bool, len(m.ig))
+		for path, ig := range m.ig {
+			m2.ig[path] = ig
+		}
+	}
+
+	return m2
+}
+
+// prepManifest converts the manifest into a simpleRootManifest, with the given
+// overrides taking precedence over any the manifest itself declares. The given
+// ignores are added to the manifest's own. Unless tests is true, the
+// manifest's test dependency constraints are left out.
+func prepManifest(m gps.Manifest, ovr gps.ProjectConstraints, ig []string, tests bool) simpleRootManifest {
+	rm := simpleRootManifest{
+		c:   make(map[gps.ProjectRoot]gps.ProjectConstraint),
+		tc:  make(map[gps.ProjectRoot]gps.ProjectConstraint),
+		ovr: make(gps.ProjectConstraints),
+	}
+
+	if m != nil {
+		for _, d := range m.DependencyConstraints() {
+			rm.c[d.Ident.ProjectRoot] = d
+		}
+		if tests {
+			for _, d := range m.TestDependencyConstraints() {
+				rm.tc[d.Ident.ProjectRoot] = d
+			}
+		}
+		if r, ok := m.(gps.RootManifest); ok {
+			for pr, pp := range r.Overrides() {
+				rm.ovr[pr] = pp
+			}
+			for path, ign := range r.IgnorePackages() {
+				if ign {
+					ig = append(ig, path)
+				}
+			}
+		}
+	}
+
+	for pr, pp := range ovr {
+		rm.ovr[pr] = pp
+	}
+
+	if len(ig) > 0 {
+		rm.ig = make(map[string]bool, len(ig))
+		for _, path := range ig {
+			rm.ig[path] = true
+		}
+	}
+
+	return rm
+}
@@ -0,0 +1,85 @@
+package sweep
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sdboyer/gps"
+)
+
+// modEnv is the environment the commands get when Modules is set. The go tool
+// must find everything in the go.mod written for the solution; if it went to
+// the network instead, the result wouldn't reflect the solution at all.
+// GOFLAGS is left alone, so that it can still be set with Env.
+var modEnv = []string{
+	"GO111MODULE=on",
+	"GOPROXY=off",
+}
+
+// writeModFiles makes the tree of deps written at mpath usable as modules by
+// the project in dir, whose import path is ir. Each dep gets a go.mod of its
+// own, replacing any it came with, as its requirements are already settled by
+// the solution; and the project gets one that requires every dep, and
+// replaces each with its directory in mpath. The go directive of the
+// project's own go.mod, if it has one, is kept.
+func writeModFiles(dir, mpath string, ir gps.ProjectRoot, s gps.Lock) error {
+	var roots []string
+	for _, lp := range s.Projects() {
+		roots = append(roots, string(lp.Ident().ProjectRoot))
+	}
+	sort.Strings(roots)
+
+	for _, root := range roots {
+		gm := filepath.Join(mpath, filepath.FromSlash(root), "go.mod")
+		if err := ioutil.WriteFile(gm, []byte(fmt.Sprintf("module %s\n", root)), 0666); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Written by gta for one solution; not the project's own.\n\nmodule %s\n", ir)
+	if gv := goDirective(filepath.Join(dir, "go.mod")); gv != "" {
+		fmt.Fprintf(&buf, "\ngo %s\n", gv)
+	}
+	if len(roots) > 0 {
+		buf.WriteString("\nrequire (\n")
+		for _, root := range roots {
+			fmt.Fprintf(&buf, "\t%s v0.0.0\n", root)
+		}
+		buf.WriteString(")\n\nreplace (\n")
+		for _, root := range roots {
+			fmt.Fprintf(&buf, "\t%s => %s\n", root, filepath.Join(mpath, filepath.FromSlash(root)))
+		}
+		buf.WriteString(")\n")
+	}
+
+	// Sums for anything in the project's own go.sum would only be for modules
+	// that are now replaced
+	os.Remove(filepath.Join(dir, "go.sum"))
+	return ioutil.WriteFile(filepath.Join(dir, "go.mod"), buf.Bytes(), 0666)
+}
+
+// goDirective returns the version in the go directive of the go.mod at path,
+// or the empty string if there isn't one.
+func goDirective(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && fields[0] == "go" {
+			return fields[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package sweep
+
+import "os/exec"
+
+// setProcessGroup is a no-op on Windows.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the command's process. On Windows, its children are
+// not killed.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,64 @@
+package sweep
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// retryBackoff is the delay before the first retry; it doubles for each
+// subsequent one.
+var retryBackoff = time.Second
+
+// transientMarkers are fragments of error messages that indicate a failure in
+// talking to the network, rather than a real problem. Much of what comes back
+// from gps has been flattened into strings (often output from a VCS command),
+// so inspecting messages is the best that can be done.
+var transientMarkers = []string{
+	"timeout",
+	"timed out",
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"temporary failure",
+	"tls handshake",
+	"could not resolve host",
+	"no route to host",
+	"network is unreachable",
+	"unexpected eof",
+	"the remote end hung up unexpectedly",
+}
+
+// IsTransient reports whether err looks like it came from a transient network
+// problem, such that retrying the operation might succeed.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if ne, ok := err.(net.Error); ok && (ne.Timeout() || ne.Temporary()) {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, m := range transientMarkers {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retry calls f until it succeeds, fails with an error that isn't transient,
+// or has been retried the given number of times, with exponential backoff in
+// between. It returns f's last error.
+func Retry(retries int, f func() error) error {
+	delay := retryBackoff
+	for i := 0; ; i++ {
+		err := f()
+		if err == nil || i >= retries || !IsTransient(err) {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
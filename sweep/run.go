@@ -0,0 +1,77 @@
+package sweep
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// runCommand runs the command described by argv in dir, and returns its
+// combined output. The command inherits this process's environment, plus any variables
+// in env. If ctx is done before the command exits, the command's whole process
+// group is killed, so that children (e.g. test binaries spawned by `go test`)
+// don't linger.
+func runCommand(ctx context.Context, dir string, argv, env []string) ([]byte, error) {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = dir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	waitc := make(chan error, 1)
+	go func() {
+		waitc <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-waitc:
+		return buf.Bytes(), err
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		<-waitc
+		return buf.Bytes(), ctx.Err()
+	}
+}
+
+// keepTree moves the vendor tree at vpath to kp, for Options.KeepVendor. If
+// it can't be moved, it's removed, just as it would be if it weren't being
+// kept, and the error says as much.
+func keepTree(vpath, kp string) error {
+	err := os.Rename(vpath, kp)
+	if err == nil {
+		return nil
+	}
+	if rerr := os.RemoveAll(vpath); rerr != nil {
+		return fmt.Errorf("%s, and it could not be removed either: %s", err, rerr)
+	}
+	return fmt.Errorf("%s, so it was removed instead", err)
+}
+
+// keepPath returns the path within dir at which to keep the vendor tree for a
+// combo. The combo is sanitized into something safe for use as a single path
+// element; used tracks the names already handed out, so they don't collide.
+func keepPath(dir string, c Combo, used map[string]bool) string {
+	name := "vend-" + c.Filename()
+
+	try := name
+	for i := 2; used[try]; i++ {
+		try = fmt.Sprintf("%s-%v", name, i)
+	}
+	used[try] = true
+
+	p := filepath.Join(dir, try)
+	// Clear out anything left over from a previous run
+	os.RemoveAll(p)
+	return p
+}
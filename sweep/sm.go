@@ -0,0 +1,159 @@
+package sweep
+
+import (
+	"sync"
+
+	"github.com/Masterminds/semver"
+	"github.com/sdboyer/gps"
+)
+
+// syncSM serializes the calls made to a SourceManager. gps's own SourceMgr
+// isn't safe for concurrent use: its sources cache what they've analyzed in
+// maps that it reads and writes without locking, so two solves that share a
+// dep can crash the process. Solves still overlap in everything they do
+// between calls to it.
+type syncSM struct {
+	mu sync.Mutex
+	sm gps.SourceManager
+}
+
+func (s *syncSM) SourceExists(id gps.ProjectIdentifier) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sm.SourceExists(id)
+}
+
+func (s *syncSM) SyncSourceFor(id gps.ProjectIdentifier) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sm.SyncSourceFor(id)
+}
+
+func (s *syncSM) ListVersions(id gps.ProjectIdentifier) ([]gps.Version, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sm.ListVersions(id)
+}
+
+func (s *syncSM) RevisionPresentIn(id gps.ProjectIdentifier, r gps.Revision) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sm.RevisionPresentIn(id, r)
+}
+
+func (s *syncSM) ListPackages(id gps.ProjectIdentifier, v gps.Version) (gps.PackageTree, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sm.ListPackages(id, v)
+}
+
+func (s *syncSM) GetManifestAndLock(id gps.ProjectIdentifier, v gps.Version) (gps.Manifest, gps.Lock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sm.GetManifestAndLock(id, v)
+}
+
+func (s *syncSM) ExportProject(id gps.ProjectIdentifier, v gps.Version, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sm.ExportProject(id, v, to)
+}
+
+func (s *syncSM) AnalyzerInfo() (string, *semver.Version) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sm.AnalyzerInfo()
+}
+
+func (s *syncSM) DeduceProjectRoot(ip string) (gps.ProjectRoot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sm.DeduceProjectRoot(ip)
+}
+
+// cachingSM remembers the manifest, lock and package tree that a
+// SourceManager gives for each revision of each source, for the length of a
+// sweep, as the solves in a sweep ask after the same revisions of most deps
+// over and over. gps's own SourceMgr keeps them too, but not every
+// SourceManager does (gta's for local repositories analyzes them afresh each
+// time), and a hit here needn't wait its turn at a syncSM. Versions that
+// aren't paired with a revision aren't cached, as what they point at may move.
+type cachingSM struct {
+	gps.SourceManager
+
+	mu     sync.Mutex
+	infos  map[revKey]revInfo
+	ptrees map[revKey]gps.PackageTree
+}
+
+type revKey struct {
+	id  gps.ProjectIdentifier
+	rev gps.Revision
+}
+
+type revInfo struct {
+	m gps.Manifest
+	l gps.Lock
+}
+
+func newCachingSM(sm gps.SourceManager) *cachingSM {
+	return &cachingSM{
+		SourceManager: sm,
+		infos:         make(map[revKey]revInfo),
+		ptrees:        make(map[revKey]gps.PackageTree),
+	}
+}
+
+// cacheKey gives the key for id at v, if v is fixed to a revision.
+func cacheKey(id gps.ProjectIdentifier, v gps.Version) (revKey, bool) {
+	switch tv := v.(type) {
+	case gps.Revision:
+		return revKey{id, tv}, true
+	case gps.PairedVersion:
+		return revKey{id, tv.Underlying()}, true
+	}
+	return revKey{}, false
+}
+
+func (c *cachingSM) GetManifestAndLock(id gps.ProjectIdentifier, v gps.Version) (gps.Manifest, gps.Lock, error) {
+	key, ok := cacheKey(id, v)
+	if !ok {
+		return c.SourceManager.GetManifestAndLock(id, v)
+	}
+	c.mu.Lock()
+	ri, has := c.infos[key]
+	c.mu.Unlock()
+	if has {
+		return ri.m, ri.l, nil
+	}
+
+	m, l, err := c.SourceManager.GetManifestAndLock(id, v)
+	// Errors aren't kept, as they may be down to the network
+	if err == nil {
+		c.mu.Lock()
+		c.infos[key] = revInfo{m, l}
+		c.mu.Unlock()
+	}
+	return m, l, err
+}
+
+func (c *cachingSM) ListPackages(id gps.ProjectIdentifier, v gps.Version) (gps.PackageTree, error) {
+	key, ok := cacheKey(id, v)
+	if !ok {
+		return c.SourceManager.ListPackages(id, v)
+	}
+	c.mu.Lock()
+	pt, has := c.ptrees[key]
+	c.mu.Unlock()
+	if has {
+		return pt, nil
+	}
+
+	pt, err := c.SourceManager.ListPackages(id, v)
+	if err == nil {
+		c.mu.Lock()
+		c.ptrees[key] = pt
+		c.mu.Unlock()
+	}
+	return pt, err
+}
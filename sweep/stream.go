@@ -0,0 +1,67 @@
+package sweep
+
+import "context"
+
+// CheckStream is like Check, but sends each Result on the returned channel as
+// soon as it's final, rather than returning them all at the end: when solving
+// fails, or else once the commands have been run (or, if there are none, once
+// it's solved). As solve failures are sent straight away, Results don't
+// necessarily arrive in combination order. Any Results that are left over when
+// the sweep ends, such as those not run because BeforeRun said not to, are
+// sent then, in combination order.
+//
+// Errors in the options, or in working out the versions to check, are
+// returned straight away. The channel is closed when the sweep is over; if ctx
+// is cancelled, that's once any in-flight command is killed and the vendor dir
+// restored, and nothing more is sent in the meantime, so the receiver needn't
+// keep draining it. An error that stops the sweep partway,
+// such as failing to back up the vendor dir, also just closes the channel; use
+// Check if the difference matters. OnSolve and OnRun, if set, are still called
+// as usual.
+func CheckStream(ctx context.Context, opts Options) (<-chan Result, error) {
+	sw, err := newSweeper(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Result)
+	sent := make(map[string]bool)
+	send := func(r Result) {
+		sent[r.Combo.String()] = true
+		select {
+		case ch <- r:
+		case <-ctx.Done():
+		}
+	}
+
+	run := len(opts.Run) > 0 || opts.RunFor != nil
+	onSolve, onRun := opts.OnSolve, opts.OnRun
+	sw.opts.OnSolve = func(r Result) {
+		if onSolve != nil {
+			onSolve(r)
+		}
+		if !r.runnable() || !run {
+			send(r)
+		}
+	}
+	sw.opts.OnRun = func(r Result) {
+		if onRun != nil {
+			onRun(r)
+		}
+		send(r)
+	}
+
+	go func() {
+		defer close(ch)
+		results, _ := sw.check(ctx)
+		for _, r := range results {
+			if ctx.Err() != nil {
+				return
+			}
+			if !sent[r.Combo.String()] {
+				send(r)
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,1096 @@
+// Package sweep checks a Go project against ranges of versions of its
+// dependencies: for each version (or combination of versions) of the target
+// dependencies, it looks for a dependency solution, and optionally runs a
+// command, like `go test`, against a vendor tree built from that solution.
+//
+// This is the engine behind the gta command, exposed so that the same
+// matrix-checking can be embedded in other tooling.
+package sweep
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sdboyer/gps"
+)
+
+// A Target is a dependency to check across versions.
+type Target struct {
+	Root gps.ProjectRoot
+
+	// Versions are the versions of the project to check. If empty, all the
+	// project's versions that match Constraint are checked, in upgrade (or, if
+	// Options.Downgrade is set, downgrade) order.
+	Versions []gps.Version
+
+	// Constraint selects the versions to check when Versions is empty. A nil
+	// Constraint matches all versions.
+	Constraint gps.Constraint
+
+	// Transitive is for a dep of a dep, that the project may not import
+	// itself. gps ignores the root's constraints on projects that it doesn't
+	// import, so such a target is put at each version with an override,
+	// rather than a constraint; that also overrides whatever the deps that
+	// import it ask for.
+	Transitive bool
+}
+
+// Options control a sweep.
+type Options struct {
+	// RootDir is the root directory of the project being checked. If a command
+	// is to be run, vendor trees are written into it.
+	RootDir string
+
+	// ImportRoot is the import path corresponding to RootDir.
+	ImportRoot gps.ProjectRoot
+
+	// Manifest and Lock are the project's own metadata. Both are optional. If
+	// the Manifest is a gps.RootManifest, its overrides are respected.
+	Manifest gps.Manifest
+	Lock     gps.Lock
+
+	// Overrides are applied to every solve, taking precedence over any
+	// overrides in Manifest.
+	Overrides gps.ProjectConstraints
+
+	// VersionOverrides are overrides that only apply to the solves in which a
+	// target is at a particular version, keyed by that version's name, for
+	// deps whose requirements changed from one version of a target to the
+	// next. They take precedence over all other overrides; if a combination
+	// has targets at more than one of the versions, those for later targets
+	// win. A target itself can't be overridden.
+	VersionOverrides map[string]gps.ProjectConstraints
+
+	// Ignore lists import paths to be ignored by the solver, in addition to
+	// any the Manifest ignores, if it's a gps.RootManifest.
+	Ignore []string
+
+	// Add lists import paths of packages that the solver should treat as
+	// imported by the project, whether or not they are, so that deps the
+	// project is thinking of adding are solved for, rather than left out of
+	// the solution for want of an import. Each must be a package with Go
+	// source in it, not just a project root.
+	Add []string
+
+	// NoTestConstraints leaves the Manifest's test dependency constraints out
+	// of every solve. The project's tests are still analyzed, so deps that
+	// only they import are still solved for, but without constraints, unless
+	// they're also targets.
+	NoTestConstraints bool
+
+	// PinLock constrains every project in Lock, other than the targets, to
+	// exactly its locked version, for every solve, so that the targets are the
+	// only deps that can change. It's done with overrides, so it reaches
+	// transitive deps, too; but any given in Overrides, or by the Manifest,
+	// are left in place.
+	PinLock bool
+
+	// Downgrade has the solver prefer the lowest acceptable versions of all
+	// projects that aren't locked, rather than the highest.
+	Downgrade bool
+
+	// SourceManager is used for all solving and tree-writing, shared across
+	// every target and every combination of their versions, so that each
+	// source is only fetched and analyzed once per sweep. It needn't be safe
+	// for concurrent use; see Jobs. It is not released by Check.
+	SourceManager gps.SourceManager
+
+	// Targets are the dependencies to check. Every combination of their
+	// versions is checked.
+	Targets []Target
+
+	// Run holds the argvs of commands to run, in sequence, against each
+	// solution. A combination fails at the first command that does. If empty,
+	// and RunFor is nil, versions are only solved.
+	Run [][]string
+
+	// RunFor, if non-nil, is called to produce the commands to run for each
+	// combination, in place of Run. An error from it is reported as the
+	// combination's RunErr.
+	RunFor func(Combo) ([][]string, error)
+
+	// PreRun, if set, is the argv of a command to run before the commands for
+	// each combination, once its tree is written, to set things up for them.
+	// It doesn't count towards passing or failing, but if it fails, the
+	// commands aren't run, and the combination's status is StatusError.
+	// PostRun, if set,
+	// is run after them, whether they or PreRun failed or not, to tear down;
+	// it's recorded, but doesn't affect the outcome. Both get the same
+	// environment as the commands, and are run once per combination, however
+	// many attempts the commands take.
+	PreRun  []string
+	PostRun []string
+
+	// Jobs is the number of solves to run in parallel. Values less than one
+	// are treated as one, as is any value when TraceLogger is set. With more
+	// than one, calls to the SourceManager are made one at a time, as gps's
+	// own can't take concurrent ones. The tests check that with a fake
+	// SourceManager; gps's own isn't exercised with concurrent solves.
+	Jobs int
+
+	// MaxAttempts is the number of attempts allowed per combination, shared
+	// between retrying solves and rerunning the command. Values less than one
+	// are treated as one.
+	MaxAttempts int
+
+	// Env holds extra KEY=VALUE environment variables for the Run commands,
+	// which otherwise inherit this process's environment. GTA_DEP_ROOT and
+	// GTA_DEP_VERSION are always set, to the root and version of the first
+	// target.
+	Env []string
+
+	// Retries is the number of times to retry operations that fail because of
+	// what look like transient network problems. Such retries don't count
+	// against MaxAttempts.
+	Retries int
+
+	// SolveTimeout, if non-zero, bounds each attempt at solving a combination
+	// (including any retries within it). A combination whose solve times out
+	// fails, and isn't attempted again. gps can't cancel a solve, so one that
+	// times out is abandoned, rather than stopped; it runs on in the
+	// background, until it finishes or the process exits. It still counts
+	// towards Jobs while it does, so the next solve may wait for it.
+	SolveTimeout time.Duration
+
+	// Timeout, if non-zero, bounds each execution of each of the Run commands.
+	Timeout time.Duration
+
+	// SuccessPattern and FailPattern, if non-nil, are for commands whose exit
+	// status can't be trusted on its own. Once every command has succeeded,
+	// their combined output must match SuccessPattern, and must not match
+	// FailPattern, for the combination to pass; otherwise, it fails as if the
+	// last command had.
+	SuccessPattern *regexp.Regexp
+	FailPattern    *regexp.Regexp
+
+	// Isolate runs the commands for each combination in a copy of the project,
+	// with its own vendor tree and GOPATH entry, rather than in RootDir. The
+	// project's own vendor directory is then left alone, and up to Jobs
+	// combinations are run at once.
+	Isolate bool
+
+	// Modules, which is experimental, runs the commands in module mode, rather
+	// than against a vendor tree. Each combination's deps are written to a
+	// directory alongside the copy of the project the commands run in (so
+	// Modules implies Isolate), and the copy's go.mod is replaced with one
+	// that requires every project in the solution, and replaces each with its
+	// directory. The go tool is kept off the network, so an import that the
+	// solution doesn't cover makes the commands fail.
+	Modules bool
+
+	// RunDir, if set, is the directory in which the commands are run, relative
+	// to RootDir (or to the copy of it, with Isolate). By default, they're run
+	// in RootDir itself.
+	RunDir string
+
+	// NoStripVendor leaves any vendor directories that deps have of their own
+	// in the trees written for them. By default they're removed, so that
+	// everything builds against the one, flattened, set of deps in the
+	// solution; a nested copy of a dep would otherwise shadow it, for the
+	// packages in the dep that vendored it.
+	NoStripVendor bool
+
+	// NoVendorBackup skips backing up the project's own vendor directory to
+	// _origvendor, and restoring it afterwards, for projects that have none.
+	// Whatever is at RootDir/vendor is written over, and removed at the end,
+	// so the caller must make sure there's nothing there to keep. It has no
+	// effect with Isolate, which leaves the project's vendor dir alone anyway.
+	NoVendorBackup bool
+
+	// KeepVendor, if set, is a directory into which each combination's vendor
+	// tree is moved after running, instead of being deleted.
+	KeepVendor string
+
+	// TraceLogger, if non-nil, receives the solver's trace output. Each solve's
+	// trace is preceded by a header naming the combination being solved.
+	TraceLogger *log.Logger
+
+	// FailFast stops the sweep at the first combination that fails, whether
+	// to solve, to have its tree written, at PreRun, or in running. Only the
+	// results up to and including that one are returned.
+	FailFast bool
+
+	// RunOnSolveFailure runs the commands even for combinations that fail to
+	// solve, for the diagnostics. gps gives back nothing from a failed solve,
+	// so the tree is made from Lock instead, with the targets at the versions
+	// being checked; see Result.Fallback. Such a combination still fails,
+	// whatever the commands do.
+	RunOnSolveFailure bool
+
+	// BeforeRun, if non-nil, is called with the results once all the solving
+	// is done, and before any commands are run. If it returns false, nothing
+	// is run, and the results are returned as they are.
+	BeforeRun func([]Result) bool
+
+	// OnSolve and OnRun, if non-nil, are called with each Result as solving,
+	// or running, completes for it. Calls are made in combination order, and
+	// never concurrently.
+	OnSolve func(Result)
+	OnRun   func(Result)
+}
+
+// A Result is the outcome of checking a single combination of versions.
+type Result struct {
+	Combo Combo
+
+	// The solution, if one was found; otherwise, the error from solving
+	Solution gps.Solution
+	SolveErr error
+
+	// Fallback is set, with Options.RunOnSolveFailure, to the tree the
+	// commands are run against when solving failed: the lock's projects, with
+	// the targets at the combination's versions. It's no solution, so
+	// anything that runs against it is only good for diagnostics.
+	Fallback gps.Lock
+
+	// Error from writing out the vendor tree, if any (in which case whatever
+	// was partly written has been removed), and whether the tree from the
+	// previous combination was reused, with only the projects that differed
+	// being rewritten
+	WriteErr error
+	Reused   bool
+
+	// Error from moving the vendor tree into Options.KeepVendor after
+	// running, if it couldn't be; the tree is then gone, rather than kept
+	KeepErr error
+
+	// Whether the commands were run, the error from the one that failed, if
+	// any, and the combined output of all of them
+	Ran    bool
+	RunErr error
+	Output []byte
+
+	// The commands that were run, on the last attempt. Running stops at the
+	// first command that fails, so if RunErr is set, it's from the last one.
+	Commands []CommandResult
+
+	// The outcomes of Options.PreRun and PostRun, if they were run
+	PreRun, PostRun *CommandResult
+
+	// The number of attempts, across both solving and running, that were made
+	Attempts int
+
+	// Total time spent solving, writing the tree, and running; and, of that,
+	// the time spent in the solver, and in the commands, across all attempts
+	Duration      time.Duration
+	SolveDuration time.Duration
+	RunDuration   time.Duration
+}
+
+// A CommandResult is the outcome of running one of the commands against a
+// combination.
+type CommandResult struct {
+	Argv   []string
+	Err    error
+	Output []byte
+}
+
+// Status is the overall outcome of a Result.
+type Status int
+
+// Statuses are ordered by how urgently they probably need attention.
+const (
+	StatusFail Status = iota
+	// The combination couldn't be checked, as its tree couldn't be written
+	// out, or Options.PreRun failed; that's a failure of a kind, too
+	StatusError
+	StatusPass
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusFail:
+		return "fail"
+	case StatusError:
+		return "error"
+	case StatusPass:
+		return "pass"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// Status reports whether the combination passed, failed, or couldn't be
+// checked because its tree couldn't be written out, or Options.PreRun failed.
+func (r Result) Status() Status {
+	switch {
+	case r.SolveErr != nil, r.RunErr != nil:
+		return StatusFail
+	case r.WriteErr != nil, r.PreRunErr() != nil:
+		return StatusError
+	}
+	return StatusPass
+}
+
+// PreRunErr returns the error from Options.PreRun, if it was run and failed.
+func (r Result) PreRunErr() error {
+	if r.PreRun == nil {
+		return nil
+	}
+	return r.PreRun.Err
+}
+
+// failed reports whether the combination failed at any stage: solving,
+// writing its tree, PreRun, or the commands.
+func (r Result) failed() bool {
+	return r.SolveErr != nil || r.WriteErr != nil || r.PreRunErr() != nil || r.RunErr != nil
+}
+
+// PostRunErr returns the error from Options.PostRun, if it was run and failed.
+func (r Result) PostRunErr() error {
+	if r.PostRun == nil {
+		return nil
+	}
+	return r.PostRun.Err
+}
+
+// runnable reports whether there's a tree to run the commands against: a
+// solution, or a fallback for a combination that failed to solve.
+func (r Result) runnable() bool {
+	return r.SolveErr == nil || r.Fallback != nil
+}
+
+// tree returns the tree that the commands are run against.
+func (r Result) tree() gps.Lock {
+	if r.SolveErr == nil {
+		return r.Solution
+	}
+	return r.Fallback
+}
+
+// MatchingVersions lists the versions of the project that match the
+// constraint, sorted in upgrade order, or in downgrade order if downgrade is
+// true. A nil constraint matches everything. Transient failures to list the
+// versions are retried up to the given number of times.
+func MatchingVersions(sm gps.SourceManager, root gps.ProjectRoot, c gps.Constraint, downgrade bool, retries int) ([]gps.Version, error) {
+	pi := gps.ProjectIdentifier{
+		ProjectRoot: root,
+	}
+	var vlist []gps.Version
+	err := Retry(retries, func() (err error) {
+		vlist, err = sm.ListVersions(pi)
+		return
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve version list for %s: %s", pi, err)
+	}
+
+	if downgrade {
+		gps.SortForDowngrade(vlist)
+	} else {
+		gps.SortForUpgrade(vlist)
+	}
+	vlist = UniqueVersions(vlist)
+	if c == nil {
+		return vlist, nil
+	}
+
+	var vl []gps.Version
+	for _, v := range vlist {
+		if c.Matches(v) {
+			vl = append(vl, v)
+		}
+	}
+	return vl, nil
+}
+
+// UniqueVersions removes duplicates from the list, preserving its order. Upstream
+// sources can report the same version more than once - e.g. a tag that's
+// present under several names for the same source - but there's no point in
+// checking it more than once. Versions are the same if they have the same
+// type and name, regardless of the revisions underlying them.
+func UniqueVersions(vl []gps.Version) []gps.Version {
+	seen := make(map[string]bool, len(vl))
+	uvl := make([]gps.Version, 0, len(vl))
+	for _, v := range vl {
+		key := v.Type() + " " + v.String()
+		if !seen[key] {
+			seen[key] = true
+			uvl = append(uvl, v)
+		}
+	}
+	return uvl
+}
+
+// A target is a Target, resolved into its list of versions and its constraint
+// as it appears in the root manifest.
+type target struct {
+	root  gps.ProjectRoot
+	focus gps.ProjectConstraint
+	vl    []gps.Version
+
+	// Whether the target is put at each version by override
+	transitive bool
+}
+
+type sweeper struct {
+	opts    Options
+	rm      simpleRootManifest
+	params  gps.SolveParameters
+	targets []target
+
+	// slots holds a token for each solve that's running, abandoned ones
+	// included, so that no more than Jobs are ever running at once
+	slots chan struct{}
+
+	// cleanup, if set, removes whatever newSweeper set up on disk for the
+	// sweep
+	cleanup func()
+}
+
+// Check solves, and optionally runs the command against, every combination of
+// the targets' versions. Results are returned in combination order, with the
+// first target's versions varying the slowest.
+//
+// An error is returned only if the sweep itself could not be carried out; the
+// failure of any individual combination is reported in its Result. If ctx is
+// cancelled, any in-flight command is killed, the project's vendor directory
+// is restored, and the results so far are returned along with ctx's error.
+func Check(ctx context.Context, opts Options) ([]Result, error) {
+	sw, err := newSweeper(opts)
+	if err != nil {
+		return nil, err
+	}
+	return sw.check(ctx)
+}
+
+// newSweeper validates the options, and does everything that needs doing
+// before any solving: working out the versions of each target, and preparing
+// the root manifest.
+func newSweeper(opts Options) (*sweeper, error) {
+	if opts.SourceManager == nil {
+		return nil, fmt.Errorf("a SourceManager must be provided")
+	}
+	if len(opts.Targets) == 0 {
+		return nil, fmt.Errorf("at least one target must be provided")
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	if opts.Modules {
+		opts.Isolate = true
+	}
+	// Concurrent solver traces would be an unreadable mess
+	if opts.Jobs < 1 || opts.TraceLogger != nil {
+		opts.Jobs = 1
+	}
+	if opts.Jobs > 1 {
+		opts.SourceManager = &syncSM{sm: opts.SourceManager}
+	}
+	opts.SourceManager = newCachingSM(opts.SourceManager)
+
+	sw := &sweeper{
+		opts: opts,
+		rm:   prepManifest(opts.Manifest, opts.Overrides, opts.Ignore, !opts.NoTestConstraints),
+		params: gps.SolveParameters{
+			Lock:        opts.Lock,
+			RootDir:     opts.RootDir,
+			ImportRoot:  opts.ImportRoot,
+			Downgrade:   opts.Downgrade,
+			Trace:       opts.TraceLogger != nil,
+			TraceLogger: opts.TraceLogger,
+		},
+		slots: make(chan struct{}, opts.Jobs),
+	}
+
+	for _, t := range opts.Targets {
+		vl := UniqueVersions(t.Versions)
+		if len(vl) == 0 {
+			var err error
+			vl, err = MatchingVersions(opts.SourceManager, t.Root, t.Constraint, opts.Downgrade, opts.Retries)
+			if err == nil && len(vl) == 0 {
+				err = fmt.Errorf("no versions of %s matched constraint %s", t.Root, t.Constraint)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		focus, has := sw.rm.c[t.Root]
+		if !has {
+			focus, has = sw.rm.tc[t.Root]
+		}
+		if !has {
+			focus = gps.ProjectConstraint{
+				Ident: gps.ProjectIdentifier{
+					ProjectRoot: t.Root,
+				},
+			}
+		}
+
+		sw.targets = append(sw.targets, target{root: t.Root, focus: focus, vl: vl, transitive: t.Transitive})
+	}
+
+	for v, ovr := range opts.VersionOverrides {
+		for root := range ovr {
+			if isTarget(opts.Targets, root) {
+				return nil, fmt.Errorf("%s is a target, so it can't be overridden for version %s", root, v)
+			}
+		}
+	}
+
+	if opts.PinLock && opts.Lock != nil {
+		for _, lp := range opts.Lock.Projects() {
+			root := lp.Ident().ProjectRoot
+			if _, has := sw.rm.ovr[root]; has || isTarget(opts.Targets, root) {
+				continue
+			}
+			sw.rm.ovr[root] = gps.ProjectProperties{
+				NetworkName: lp.Ident().NetworkName,
+				Constraint:  lp.Version(),
+			}
+		}
+	}
+
+	// Only the solver sees the deps being added; trees are still written into,
+	// and commands run in, the project itself
+	if len(opts.Add) > 0 {
+		tmp, dir, err := addRoot(opts.RootDir, opts.ImportRoot, opts.Add)
+		if err != nil {
+			return nil, fmt.Errorf("could not make a copy of the project to solve with added deps: %s", err)
+		}
+		sw.cleanup = func() { os.RemoveAll(tmp) }
+		sw.params.RootDir = dir
+	}
+
+	return sw, nil
+}
+
+// check carries out the sweep that sw was set up for.
+func (sw *sweeper) check(ctx context.Context) ([]Result, error) {
+	if sw.cleanup != nil {
+		defer sw.cleanup()
+	}
+	return sw.checkCombos(ctx, combos(sw.targets))
+}
+
+// checkCombos solves, and runs the commands against, the given combos.
+func (sw *sweeper) checkCombos(ctx context.Context, cl []Combo) ([]Result, error) {
+	opts := sw.opts
+
+	results := sw.solveAll(ctx, cl)
+	if ctx.Err() != nil {
+		return results, ctx.Err()
+	}
+	if opts.FailFast {
+		results = untilFailure(results)
+		if results[len(results)-1].failed() {
+			return results, nil
+		}
+	}
+
+	if (len(opts.Run) > 0 || opts.RunFor != nil) && (opts.BeforeRun == nil || opts.BeforeRun(results)) {
+		err := sw.runAll(ctx, results)
+		if opts.FailFast {
+			results = untilFailure(results)
+		}
+		if err != nil {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+// isTarget reports whether root is one of the targets.
+func isTarget(targets []Target, root gps.ProjectRoot) bool {
+	for _, t := range targets {
+		if t.Root == root {
+			return true
+		}
+	}
+	return false
+}
+
+// solve finds a solution for a single combination of versions of the targets.
+// It's safe to call concurrently, as each call gets its own copy of the root
+// manifest.
+func (sw *sweeper) solve(c Combo) Result {
+	vrm := sw.rm.clone()
+	for k, av := range c {
+		vf := sw.targets[k].focus
+		if sw.targets[k].transitive {
+			pp := gps.ProjectProperties{NetworkName: vf.Ident.NetworkName, Constraint: av.Version}
+			if ovr, has := vrm.ovr[av.Root]; has && ovr.NetworkName != "" {
+				pp.NetworkName = ovr.NetworkName
+			}
+			vrm.ovr[av.Root] = pp
+		} else {
+			vf.Constraint = av.Version
+			vrm.c[av.Root] = vf
+			// A test constraint on the target would otherwise be intersected
+			// with the version being checked
+			delete(vrm.tc, av.Root)
+		}
+
+		for root, pp := range sw.opts.VersionOverrides[av.Version.String()] {
+			// Keep the source from any other override, unless this names one
+			if pp.NetworkName == "" {
+				pp.NetworkName = vrm.ovr[root].NetworkName
+			}
+			vrm.ovr[root] = pp
+		}
+	}
+
+	params := sw.params
+	params.Manifest = vrm
+
+	r := Result{Combo: c}
+	start := time.Now()
+	for {
+		r.Attempts++
+		// gps offers no way to reuse a prepared solver with a different root
+		// manifest, so each attempt needs its own. Only the cheap validation
+		// in Prepare is repeated, though; the root's manifest and lock were
+		// derived once, up front.
+		if sw.opts.TraceLogger != nil {
+			sw.opts.TraceLogger.Printf("=== Solving with %s (attempt %v) ===", c, r.Attempts)
+		}
+
+		var timedOut bool
+		r.Solution, timedOut, r.SolveErr = sw.solveOnce(params)
+		if r.SolveErr == nil || timedOut || r.Attempts >= sw.opts.MaxAttempts {
+			break
+		}
+	}
+	r.SolveDuration = time.Since(start)
+	r.Duration = r.SolveDuration
+	if r.SolveErr != nil && sw.opts.RunOnSolveFailure {
+		r.Fallback = sw.fallback(c)
+	}
+	return r
+}
+
+// fallback makes the tree that c's commands are run against, with
+// RunOnSolveFailure, when it fails to solve: every project in the lock, apart
+// from the targets, at its locked version, and the targets at c's versions.
+func (sw *sweeper) fallback(c Combo) gps.Lock {
+	var l gps.SimpleLock
+	for k, av := range c {
+		l = append(l, gps.NewLockedProject(sw.targets[k].focus.Ident, av.Version, nil))
+	}
+	if sw.opts.Lock != nil {
+		for _, lp := range sw.opts.Lock.Projects() {
+			if !c.Has(lp.Ident().ProjectRoot) {
+				l = append(l, lp)
+			}
+		}
+	}
+	return l
+}
+
+// solveOnce makes a single attempt at solving, subject to SolveTimeout, and
+// reports whether it timed out.
+func (sw *sweeper) solveOnce(params gps.SolveParameters) (gps.Solution, bool, error) {
+	type outcome struct {
+		soln gps.Solution
+		err  error
+	}
+	// Buffered, so that an abandoned solve can still deliver its outcome, and
+	// exit
+	outc := make(chan outcome, 1)
+	sw.slots <- struct{}{}
+	go func() {
+		// The slot is only given up once the solve really is done, even if
+		// it's been abandoned
+		defer func() { <-sw.slots }()
+		var o outcome
+		o.err = Retry(sw.opts.Retries, func() error {
+			s, err := gps.Prepare(params, sw.opts.SourceManager)
+			if err == nil {
+				o.soln, err = solve(s)
+			}
+			return err
+		})
+		outc <- o
+	}()
+
+	var timeoutc <-chan time.Time
+	if sw.opts.SolveTimeout > 0 {
+		t := time.NewTimer(sw.opts.SolveTimeout)
+		defer t.Stop()
+		timeoutc = t.C
+	}
+
+	select {
+	case o := <-outc:
+		return o.soln, false, o.err
+	case <-timeoutc:
+		return nil, true, fmt.Errorf("solve timed out after %s", sw.opts.SolveTimeout)
+	}
+}
+
+// solve runs the solver, turning a panic into an error. gps panics, rather
+// than failing, if the project root of one of the root project's own imports
+// can't be worked out (e.g. its source can't be reached, or isn't in the
+// cache offline); that's the failure of one combination, so it mustn't take
+// the whole process down with it, and leave the cache locked.
+func solve(s gps.Solver) (soln gps.Solution, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("the solver gave up unexpectedly: %s", panicMsg(p))
+		}
+	}()
+	return s.Solve()
+}
+
+// panicMsg gives the message of a panic from gps, without the prefixes, such
+// as "canary - shouldn't be possible", that only make sense to its authors.
+func panicMsg(p interface{}) string {
+	msg := fmt.Sprint(p)
+	for _, pre := range []string{"canary - ", "shouldn't be possible "} {
+		msg = strings.TrimPrefix(msg, pre)
+	}
+	return msg
+}
+
+// solveAll solves all the combos across a bounded pool of workers. If ctx is
+// cancelled, combos that haven't yet been started are not solved.
+func (sw *sweeper) solveAll(ctx context.Context, cl []Combo) []Result {
+	// Failing fast stops new solves from being started, just as cancellation
+	// does
+	sctx, scancel := context.WithCancel(ctx)
+	defer scancel()
+
+	results := make([]Result, len(cl))
+	jobc, donec := make(chan int), make(chan int)
+	for i := 0; i < sw.opts.Jobs; i++ {
+		go func() {
+			for k := range jobc {
+				if sctx.Err() != nil {
+					results[k] = Result{Combo: cl[k], SolveErr: sctx.Err()}
+				} else {
+					results[k] = sw.solve(cl[k])
+				}
+				donec <- k
+			}
+		}()
+	}
+	go func() {
+		for k := range cl {
+			jobc <- k
+		}
+		close(jobc)
+	}()
+
+	// Report results as they come in, but always in combo order
+	done := make([]bool, len(cl))
+	var next int
+	for range cl {
+		done[<-donec] = true
+		for ; next < len(cl) && done[next]; next++ {
+			if sctx.Err() != nil {
+				continue
+			}
+			if sw.opts.OnSolve != nil {
+				sw.opts.OnSolve(results[next])
+			}
+			if sw.opts.FailFast && results[next].SolveErr != nil {
+				scancel()
+			}
+		}
+	}
+
+	return results
+}
+
+// untilFailure truncates the results after the first failure, if there is one.
+func untilFailure(results []Result) []Result {
+	for k, r := range results {
+		if r.failed() {
+			return results[:k+1]
+		}
+	}
+	return results
+}
+
+// runAll writes out the vendor tree for each solved combo in turn, and runs
+// the command against it. The project's own vendor directory is moved aside
+// for the duration, and restored when runAll returns; unless Isolate is set,
+// in which case it's all left to runIsolated.
+func (sw *sweeper) runAll(ctx context.Context, results []Result) error {
+	keep := sw.opts.KeepVendor
+	kept := make(map[string]bool)
+	if keep != "" {
+		var err error
+		keep, err = filepath.Abs(keep)
+		if err == nil {
+			err = os.MkdirAll(keep, 0777)
+		}
+		if err != nil {
+			return fmt.Errorf("could not create directory for kept vendor trees: %s", err)
+		}
+	}
+
+	if sw.opts.Isolate {
+		return sw.runIsolated(ctx, results, keep, kept)
+	}
+
+	vpath := filepath.Join(sw.opts.RootDir, "vendor")
+	ovpath := filepath.Join(sw.opts.RootDir, "_origvendor")
+
+	var err error
+	var hasVendor bool
+	if !sw.opts.NoVendorBackup {
+		// Don't clobber a backup left behind by an earlier, interrupted sweep
+		if _, err = os.Stat(ovpath); err == nil {
+			return fmt.Errorf("%s already exists; refusing to overwrite what may be the original vendor dir", ovpath)
+		}
+
+		// If we have to create these vendor trees, then back up the original
+		// vendor
+		_, err = os.Stat(vpath)
+		hasVendor = err == nil
+		if hasVendor {
+			if err = os.Rename(vpath, ovpath); err != nil {
+				return fmt.Errorf("failed to back up vendor folder: %s", err)
+			}
+		}
+	}
+
+	defer func() {
+		// Clear out any partially-written tree before putting back the
+		// original vendor dir
+		os.RemoveAll(vpath)
+		if hasVendor {
+			os.Rename(ovpath, vpath)
+		}
+	}()
+
+	// The projects in the tree currently at vpath, if it can be reused
+	var prev map[gps.ProjectRoot]string
+	for k := range results {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		r := &results[k]
+		// If solving failed, no point in even checking the run, unless
+		// there's a fallback tree to run against
+		if !r.runnable() {
+			continue
+		}
+
+		sw.runCombo(ctx, r, sw.opts.RootDir, vpath, prev, nil)
+		prev = nil
+		if r.WriteErr != nil {
+			if sw.opts.OnRun != nil {
+				sw.opts.OnRun(*r)
+			}
+			if sw.opts.FailFast {
+				return nil
+			}
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// Leave the tree in place for the next combo to reuse, unless it's
+		// being kept
+		if keep == "" {
+			prev = treeProjects(r.tree())
+		} else {
+			r.KeepErr = keepTree(vpath, keepPath(keep, r.Combo, kept))
+		}
+		if sw.opts.OnRun != nil {
+			sw.opts.OnRun(*r)
+		}
+
+		if sw.opts.FailFast && r.failed() {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// runCombo writes out the tree for a solved combo at vpath, reusing the tree
+// described by prev if it can, and then runs the commands against it in dir.
+// The commands get env, in addition to the usual environment.
+func (sw *sweeper) runCombo(ctx context.Context, r *Result, dir, vpath string, prev map[gps.ProjectRoot]string, env []string) {
+	start := time.Now()
+	defer func() {
+		r.Duration += time.Since(start)
+	}()
+
+	r.Reused, r.WriteErr = sw.writeTree(vpath, r.tree(), prev)
+	if r.WriteErr == nil && sw.opts.Modules {
+		r.WriteErr = writeModFiles(dir, vpath, sw.opts.ImportRoot, r.tree())
+	}
+	if r.WriteErr != nil {
+		// Don't leave a partly written tree behind for anything to trip over;
+		// prev no longer describes what's there, so the next combo's tree
+		// is written from scratch
+		if err := os.RemoveAll(vpath); err != nil {
+			r.WriteErr = fmt.Errorf("%s (and the partly written tree could not be removed: %s)", r.WriteErr, err)
+		}
+		return
+	}
+
+	cmds := sw.opts.Run
+	if sw.opts.RunFor != nil {
+		cmds, r.RunErr = sw.opts.RunFor(r.Combo)
+	}
+	for _, argv := range cmds {
+		if r.RunErr == nil && len(argv) == 0 {
+			r.RunErr = fmt.Errorf("empty command for %s", r.Combo)
+		}
+	}
+
+	env = append(append([]string{
+		"GTA_DEP_ROOT=" + string(r.Combo[0].Root),
+		"GTA_DEP_VERSION=" + r.Combo[0].Version.String(),
+	}, sw.opts.Env...), env...)
+
+	if r.RunErr != nil {
+		return
+	}
+	if len(sw.opts.PostRun) > 0 {
+		// Teardown is still wanted if the sweep's being stopped
+		defer func() {
+			cr := sw.runOne(context.Background(), dir, sw.opts.PostRun, env)
+			r.PostRun = &cr
+		}()
+	}
+	if len(sw.opts.PreRun) > 0 {
+		cr := sw.runOne(ctx, dir, sw.opts.PreRun, env)
+		if r.PreRun = &cr; cr.Err != nil {
+			return
+		}
+	}
+
+	// Rerun flaky commands for as long as the combo's attempt budget allows
+	for r.RunErr == nil {
+		rstart := time.Now()
+		sw.runCommands(ctx, r, dir, cmds, env)
+		r.RunDuration += time.Since(rstart)
+		r.Ran = true
+		if r.RunErr == nil || r.Attempts >= sw.opts.MaxAttempts || ctx.Err() != nil {
+			break
+		}
+		r.Attempts++
+		r.RunErr = nil
+	}
+}
+
+// runCommands runs each of the commands in turn, stopping at the first that
+// fails, and records their outcomes in r.
+func (sw *sweeper) runCommands(ctx context.Context, r *Result, dir string, cmds [][]string, env []string) {
+	r.Commands, r.Output = nil, nil
+	for _, argv := range cmds {
+		cr := sw.runOne(ctx, dir, argv, env)
+		r.Commands = append(r.Commands, cr)
+		r.Output = append(r.Output, cr.Output...)
+		if r.RunErr = cr.Err; r.RunErr != nil {
+			return
+		}
+	}
+
+	if re := sw.opts.SuccessPattern; re != nil && !re.Match(r.Output) {
+		r.RunErr = fmt.Errorf("output that doesn't match `%s`", re)
+	} else if re := sw.opts.FailPattern; re != nil {
+		if m := re.Find(r.Output); m != nil {
+			r.RunErr = fmt.Errorf("output matching `%s` (at %q)", re, m)
+		}
+	}
+}
+
+// runOne runs a single command, in the run dir within dir, subject to
+// Options.Timeout.
+func (sw *sweeper) runOne(ctx context.Context, dir string, argv, env []string) CommandResult {
+	rctx, rcancel := ctx, context.CancelFunc(func() {})
+	if sw.opts.Timeout > 0 {
+		rctx, rcancel = context.WithTimeout(ctx, sw.opts.Timeout)
+	}
+	defer rcancel()
+
+	cr := CommandResult{Argv: argv}
+	cr.Output, cr.Err = runCommand(rctx, filepath.Join(dir, sw.opts.RunDir), argv, env)
+	if rctx.Err() == context.DeadlineExceeded {
+		cr.Err = fmt.Errorf("timed out after %s", sw.opts.Timeout)
+	}
+	return cr
+}
+
+// writeTree writes out the dep tree of the solution (or fallback) at vpath. If prev describes the
+// tree that's already there, only the projects that differ from it are
+// rewritten; adjacent combos' solutions often differ only in the targets.
+func (sw *sweeper) writeTree(vpath string, s gps.Lock, prev map[gps.ProjectRoot]string) (bool, error) {
+	cur := treeProjects(s)
+	if prev == nil || nestedRoots(prev, cur) {
+		// Whatever was there, be it an earlier tree or a partly written one,
+		// mustn't be mixed into this one
+		if err := os.RemoveAll(vpath); err != nil {
+			return false, fmt.Errorf("could not clear out the previous tree: %s", err)
+		}
+		return false, gps.WriteDepTree(vpath, s, sw.opts.SourceManager, !sw.opts.NoStripVendor)
+	}
+
+	var changed gps.SimpleLock
+	var stale []gps.ProjectRoot
+	for _, lp := range s.Projects() {
+		root := lp.Ident().ProjectRoot
+		if prev[root] != cur[root] {
+			changed = append(changed, lp)
+			stale = append(stale, root)
+		}
+	}
+	for root := range prev {
+		if _, has := cur[root]; !has {
+			stale = append(stale, root)
+		}
+	}
+	for _, root := range stale {
+		if err := os.RemoveAll(filepath.Join(vpath, filepath.FromSlash(string(root)))); err != nil {
+			return true, fmt.Errorf("could not clear out %s from the previous tree: %s", root, err)
+		}
+	}
+
+	if len(changed) == 0 {
+		return true, nil
+	}
+	return true, gps.WriteDepTree(vpath, changed, sw.opts.SourceManager, !sw.opts.NoStripVendor)
+}
+
+// treeProjects describes the tree that would be written for s, as
+// a map of each project's root to its source and exact version.
+func treeProjects(s gps.Lock) map[gps.ProjectRoot]string {
+	m := make(map[gps.ProjectRoot]string)
+	for _, lp := range s.Projects() {
+		id, v := lp.Ident(), lp.Version()
+		key := fmt.Sprintf("%s %s %s", id.NetworkName, v.Type(), v)
+		if pv, ok := v.(gps.PairedVersion); ok {
+			key += " " + pv.Underlying().String()
+		}
+		m[id.ProjectRoot] = key
+	}
+	return m
+}
+
+// nestedRoots reports whether any of the project roots across the trees is
+// within another; removing the outer one's dir to rewrite it would clobber
+// the inner one, so such trees can't be rewritten piecemeal.
+func nestedRoots(trees ...map[gps.ProjectRoot]string) bool {
+	var roots []string
+	for _, t := range trees {
+		for root := range t {
+			roots = append(roots, string(root))
+		}
+	}
+
+	for _, a := range roots {
+		for _, b := range roots {
+			if strings.HasPrefix(b, a+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,452 @@
+package sweep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/sdboyer/gps"
+)
+
+func TestUniqueVersions(t *testing.T) {
+	rev := gps.Revision("d2abc5c5ca6c1ea5fc5a3e00e1d2dc1a1658b434")
+	other := gps.Revision("7d15d48e1d2dc1a1658b434d2abc5c5ca6c1ea5f")
+	vl := []gps.Version{
+		gps.NewVersion("v1.2.0").Is(rev),
+		gps.NewVersion("v1.1.0"),
+		// The same tag again, as if from another name for the same source
+		gps.NewVersion("v1.2.0").Is(other),
+		gps.NewBranch("master"),
+		gps.NewVersion("v1.1.0"),
+		// Same name, but a different type, so not a duplicate
+		gps.NewVersion("master"),
+		gps.NewBranch("master").Is(rev),
+	}
+	want := []string{"semver v1.2.0", "semver v1.1.0", "branch master", "version master"}
+
+	got := UniqueVersions(vl)
+	if len(got) != len(want) {
+		t.Fatalf("UniqueVersions gave %v; want %v", got, want)
+	}
+	for k, v := range got {
+		if s := v.Type() + " " + v.String(); s != want[k] {
+			t.Errorf("version %v is %s; want %s", k, s, want[k])
+		}
+	}
+	// The first of each is the one kept
+	if pv, ok := got[0].(gps.PairedVersion); !ok || pv.Underlying() != rev {
+		t.Errorf("kept %#v for v1.2.0; want the first one, at %s", got[0], rev)
+	}
+}
+
+func TestDuplicateVersionsCheckedOnce(t *testing.T) {
+	sw, err := newSweeper(Options{
+		SourceManager: newFakeSM(nil),
+		Targets: []Target{{
+			Root: "github.com/foo/bar",
+			Versions: []gps.Version{
+				gps.NewVersion("v1.0.0"),
+				gps.NewVersion("v1.1.0"),
+				gps.NewVersion("v1.0.0"),
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := combos(sw.targets)
+	if len(cl) != 2 || cl[0].String() != "github.com/foo/bar@v1.0.0" || cl[1].String() != "github.com/foo/bar@v1.1.0" {
+		t.Errorf("got combos %v; want v1.0.0 and v1.1.0, once each", cl)
+	}
+}
+
+func TestSourceManagerCallsSerialized(t *testing.T) {
+	root := newProject(t, "github.com/foo/bar", "github.com/foo/baz")
+	defer os.RemoveAll(root)
+
+	var vl []gps.Version
+	for i := 0; i < 8; i++ {
+		vl = append(vl, gps.NewVersion(fmt.Sprintf("v1.%v.0", i)).Is(gps.Revision(fmt.Sprintf("%07d", i))))
+	}
+	sm := newFakeSM(map[gps.ProjectRoot][]gps.Version{
+		"github.com/foo/bar": vl,
+		"github.com/foo/baz": vl,
+	})
+	sm.delay = time.Millisecond
+
+	results, err := Check(context.Background(), Options{
+		RootDir:       root,
+		ImportRoot:    "example.com/proj",
+		SourceManager: sm,
+		Targets:       []Target{{Root: "github.com/foo/bar"}},
+		Jobs:          4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.SolveErr != nil {
+			t.Errorf("%s failed to solve: %s", r.Combo, r.SolveErr)
+		}
+	}
+	// Every solve needs baz, so they'd all be at it at once, if let
+	if sm.maxInflight != 1 {
+		t.Errorf("up to %v calls to the SourceManager were in progress at once; want 1", sm.maxInflight)
+	}
+}
+
+func TestNoPartialTreeAfterWriteFailure(t *testing.T) {
+	root := newProject(t, "github.com/foo/bar", "github.com/foo/baz")
+	defer os.RemoveAll(root)
+	// The project's own vendor dir, which must be put back at the end
+	orig := filepath.Join(root, "vendor", "orig.txt")
+	if err := os.MkdirAll(filepath.Dir(orig), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(orig, []byte("mine"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	sm := newFakeSM(nil)
+	// Once bar is written at v1.1.0, the dir baz is to be written into can't
+	// be made; gps doesn't clean up after that itself
+	sm.export = func(id gps.ProjectIdentifier, v gps.Version, to string) error {
+		if id.ProjectRoot != "github.com/foo/bar" || v.String() != "v1.1.0" {
+			return nil
+		}
+		if err := ioutil.WriteFile(filepath.Join(to, "stale.go"), []byte("package bar\n"), 0666); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(filepath.Dir(to), "baz"), nil, 0444)
+	}
+
+	vpath := filepath.Join(root, "vendor")
+	var leaked []string
+	sw := &sweeper{opts: Options{
+		RootDir:       root,
+		ImportRoot:    "example.com/proj",
+		SourceManager: sm,
+		// The next tree must be whole, with nothing left of the one that failed
+		Run: [][]string{{"sh", "-c", "test -f vendor/github.com/foo/baz/x.go && test ! -e vendor/github.com/foo/bar/stale.go"}},
+		OnRun: func(r Result) {
+			if r.WriteErr == nil {
+				return
+			}
+			filepath.Walk(vpath, func(p string, fi os.FileInfo, err error) error {
+				if err == nil {
+					leaked = append(leaked, p)
+				}
+				return nil
+			})
+		},
+	}}
+	// Fallback trees, unlike solutions, are written in a set order: bar, then
+	// baz
+	tree := func(v string) gps.Lock {
+		return gps.SimpleLock{
+			gps.NewLockedProject(gps.ProjectIdentifier{ProjectRoot: "github.com/foo/bar"}, gps.NewVersion(v).Is("aaaaaaa"), nil),
+			gps.NewLockedProject(gps.ProjectIdentifier{ProjectRoot: "github.com/foo/baz"}, gps.NewVersion("v1.0.0").Is("bbbbbbb"), nil),
+		}
+	}
+	results := []Result{
+		{Combo: Combo{{Root: "github.com/foo/bar", Version: gps.NewVersion("v1.1.0")}}, SolveErr: errors.New("no solution"), Fallback: tree("v1.1.0")},
+		{Combo: Combo{{Root: "github.com/foo/bar", Version: gps.NewVersion("v1.0.0")}}, SolveErr: errors.New("no solution"), Fallback: tree("v1.0.0")},
+	}
+
+	if err := sw.runAll(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+	if results[0].WriteErr == nil {
+		t.Fatalf("writing the tree for %s should have failed", results[0].Combo)
+	}
+	if len(leaked) > 0 {
+		t.Errorf("after writing the tree for %s failed, these were left behind: %s", results[0].Combo, leaked)
+	}
+	if r := results[1]; r.WriteErr != nil || r.RunErr != nil {
+		t.Errorf("%s: write error %v, run error %v, output:\n%s", r.Combo, r.WriteErr, r.RunErr, r.Output)
+	}
+
+	if b, err := ioutil.ReadFile(orig); err != nil || string(b) != "mine" {
+		t.Errorf("the project's vendor dir wasn't put back: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "_origvendor")); !os.IsNotExist(err) {
+		t.Errorf("_origvendor was left behind")
+	}
+}
+
+func TestFailFastStopsAtWriteFailure(t *testing.T) {
+	root := newProject(t, "github.com/foo/bar")
+	defer os.RemoveAll(root)
+
+	sm := newFakeSM(nil)
+	sm.export = func(id gps.ProjectIdentifier, v gps.Version, to string) error {
+		if v.String() == "v1.1.0" {
+			return errors.New("disk full")
+		}
+		return nil
+	}
+	sw := &sweeper{opts: Options{
+		RootDir:       root,
+		ImportRoot:    "example.com/proj",
+		SourceManager: sm,
+		Run:           [][]string{{"true"}},
+		FailFast:      true,
+	}}
+	var results []Result
+	for _, v := range []string{"v1.1.0", "v1.0.0"} {
+		results = append(results, Result{
+			Combo:    Combo{{Root: "github.com/foo/bar", Version: gps.NewVersion(v)}},
+			SolveErr: errors.New("no solution"),
+			Fallback: gps.SimpleLock{gps.NewLockedProject(gps.ProjectIdentifier{ProjectRoot: "github.com/foo/bar"}, gps.NewVersion(v).Is("aaaaaaa"), nil)},
+		})
+	}
+
+	if err := sw.runAll(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+	if results[0].WriteErr == nil {
+		t.Fatalf("writing the tree for %s should have failed", results[0].Combo)
+	}
+	if results[1].Ran {
+		t.Errorf("%s was run after %s failed to be written out", results[1].Combo, results[0].Combo)
+	}
+	if got := untilFailure(results); len(got) != 1 {
+		t.Errorf("fail-fast kept %v results; want just the one that failed", len(got))
+	}
+}
+
+func TestKeepFailureReported(t *testing.T) {
+	root := newProject(t)
+	defer os.RemoveAll(root)
+	keep := filepath.Join(root, "kept")
+
+	var seen error
+	sw := &sweeper{opts: Options{
+		RootDir:       root,
+		ImportRoot:    "example.com/proj",
+		SourceManager: newFakeSM(nil),
+		KeepVendor:    keep,
+		// Leave nowhere for the tree to be kept
+		Run: [][]string{{"sh", "-c", "rm -r kept && touch kept"}},
+		OnRun: func(r Result) {
+			seen = r.KeepErr
+		},
+	}}
+	results := []Result{{
+		Combo:    Combo{{Root: "example.com/dep", Version: gps.NewVersion("v1.0.0")}},
+		SolveErr: errors.New("no solution"),
+		Fallback: gps.SimpleLock{},
+	}}
+
+	if err := sw.runAll(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+	r := results[0]
+	if r.RunErr != nil {
+		t.Fatalf("command failed with %s, output:\n%s", r.RunErr, r.Output)
+	}
+	if r.KeepErr == nil {
+		t.Fatalf("the tree couldn't have been kept, but no error was recorded")
+	}
+	if seen == nil {
+		t.Errorf("OnRun wasn't told that the tree couldn't be kept")
+	}
+	if _, err := os.Stat(filepath.Join(root, "vendor")); !os.IsNotExist(err) {
+		t.Errorf("the tree that couldn't be kept was left in the project")
+	}
+}
+
+func TestUnpairedVersionsNotCached(t *testing.T) {
+	sm := newFakeSM(nil)
+	c := newCachingSM(sm)
+	id := gps.ProjectIdentifier{ProjectRoot: "github.com/foo/bar"}
+	for _, v := range []gps.Version{
+		gps.NewBranch("master").Is("aaaaaaa"),
+		gps.NewBranch("master").Is("aaaaaaa"),
+		gps.Revision("aaaaaaa"),
+		// Where these point could have moved between calls
+		gps.NewBranch("master"),
+		gps.NewBranch("master"),
+	} {
+		if _, _, err := c.GetManifestAndLock(id, v); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.ListPackages(id, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Once for the revision, however it was given, and once for each of the
+	// unpaired branches
+	for _, method := range []string{"GetManifestAndLock", "ListPackages"} {
+		if n := sm.calls[method]; n != 3 {
+			t.Errorf("%s reached the SourceManager %v times; want 3", method, n)
+		}
+	}
+}
+
+// BenchmarkSweep20Deps solves every version of one dep of a project that has
+// 20. All that should be redone for each version is the solve itself: the
+// root's manifest and the solve parameters are only worked out once, and each
+// revision of a dep is only analyzed once per sweep. Besides the time, it
+// reports how many times per version a dep's manifest or package tree is
+// asked of the SourceManager, which, unlike gps's own, doesn't cache them;
+// about one per dep in the first solve, and one for the target in each
+// after it, is what the sweep's cache should bring that down to.
+func BenchmarkSweep20Deps(b *testing.B) {
+	var imports []string
+	versions := make(map[gps.ProjectRoot][]gps.Version)
+	for i := 0; i < 20; i++ {
+		ip := fmt.Sprintf("github.com/foo/dep%02d", i)
+		imports = append(imports, ip)
+		for j := 0; j < 5; j++ {
+			versions[gps.ProjectRoot(ip)] = append(versions[gps.ProjectRoot(ip)], gps.NewVersion(fmt.Sprintf("v1.%v.0", j)).Is(gps.Revision(fmt.Sprintf("%02d%02d000", i, j))))
+		}
+	}
+	root := newProject(b, imports...)
+	defer os.RemoveAll(root)
+	sm := newFakeSM(versions)
+	opts := Options{
+		RootDir:       root,
+		ImportRoot:    "example.com/proj",
+		SourceManager: sm,
+		Targets:       []Target{{Root: "github.com/foo/dep00"}},
+	}
+
+	var n int
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		results, err := Check(context.Background(), opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, r := range results {
+			if r.SolveErr != nil {
+				b.Fatalf("%s failed to solve: %s", r.Combo, r.SolveErr)
+			}
+		}
+		n += len(results)
+	}
+	b.ReportMetric(float64(sm.calls["GetManifestAndLock"])/float64(n), "manifests/version")
+	b.ReportMetric(float64(sm.calls["ListPackages"])/float64(n), "trees/version")
+}
+
+// A fakeSM is a gps.SourceManager for tests, which serves projects that each
+// have a single package, with no imports, at the versions given for them. It
+// counts how many times each of its methods is called, and how many calls are
+// ever in progress at once.
+type fakeSM struct {
+	gps.SourceManager
+	versions map[gps.ProjectRoot][]gps.Version
+
+	// If set, ExportProject calls this after writing the project out, and
+	// returns what it does
+	export func(id gps.ProjectIdentifier, v gps.Version, to string) error
+
+	// If set, each call takes at least this long, to give concurrent ones a
+	// chance to overlap
+	delay time.Duration
+
+	mu          sync.Mutex
+	calls       map[string]int
+	inflight    int
+	maxInflight int
+}
+
+func newFakeSM(versions map[gps.ProjectRoot][]gps.Version) *fakeSM {
+	return &fakeSM{versions: versions, calls: make(map[string]int)}
+}
+
+// count notes the start of a call to method, and returns the func to note its
+// end.
+func (sm *fakeSM) count(method string) func() {
+	sm.mu.Lock()
+	sm.calls[method]++
+	sm.inflight++
+	if sm.inflight > sm.maxInflight {
+		sm.maxInflight = sm.inflight
+	}
+	sm.mu.Unlock()
+	time.Sleep(sm.delay)
+	return func() {
+		sm.mu.Lock()
+		sm.inflight--
+		sm.mu.Unlock()
+	}
+}
+
+func (sm *fakeSM) SourceExists(id gps.ProjectIdentifier) (bool, error) {
+	defer sm.count("SourceExists")()
+	_, has := sm.versions[id.ProjectRoot]
+	return has, nil
+}
+
+func (sm *fakeSM) SyncSourceFor(id gps.ProjectIdentifier) error {
+	defer sm.count("SyncSourceFor")()
+	return nil
+}
+
+func (sm *fakeSM) ListVersions(id gps.ProjectIdentifier) ([]gps.Version, error) {
+	defer sm.count("ListVersions")()
+	return sm.versions[id.ProjectRoot], nil
+}
+
+func (sm *fakeSM) RevisionPresentIn(id gps.ProjectIdentifier, r gps.Revision) (bool, error) {
+	defer sm.count("RevisionPresentIn")()
+	return true, nil
+}
+
+func (sm *fakeSM) ListPackages(id gps.ProjectIdentifier, v gps.Version) (gps.PackageTree, error) {
+	defer sm.count("ListPackages")()
+	root := string(id.ProjectRoot)
+	return gps.PackageTree{
+		ImportRoot: root,
+		Packages: map[string]gps.PackageOrErr{
+			root: {P: gps.Package{ImportPath: root, Name: path.Base(root)}},
+		},
+	}, nil
+}
+
+func (sm *fakeSM) GetManifestAndLock(id gps.ProjectIdentifier, v gps.Version) (gps.Manifest, gps.Lock, error) {
+	defer sm.count("GetManifestAndLock")()
+	return gps.SimpleManifest{}, nil, nil
+}
+
+func (sm *fakeSM) ExportProject(id gps.ProjectIdentifier, v gps.Version, to string) error {
+	defer sm.count("ExportProject")()
+	if err := os.MkdirAll(to, 0777); err != nil {
+		return err
+	}
+	src := fmt.Sprintf("package %s\n", path.Base(string(id.ProjectRoot)))
+	if err := ioutil.WriteFile(filepath.Join(to, "x.go"), []byte(src), 0666); err != nil {
+		return err
+	}
+	if sm.export != nil {
+		return sm.export(id, v, to)
+	}
+	return nil
+}
+
+func (sm *fakeSM) AnalyzerInfo() (string, *semver.Version) {
+	defer sm.count("AnalyzerInfo")()
+	v, _ := semver.NewVersion("1.0.0")
+	return "fake", v
+}
+
+func (sm *fakeSM) DeduceProjectRoot(ip string) (gps.ProjectRoot, error) {
+	defer sm.count("DeduceProjectRoot")()
+	for root := range sm.versions {
+		if ip == string(root) || strings.HasPrefix(ip, string(root)+"/") {
+			return root, nil
+		}
+	}
+	return "", fmt.Errorf("no source for %s", ip)
+}
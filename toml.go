@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A tomlTable is one table from a TOML document. Values are strings, or
+// []interface{} for arrays; anything that isn't a string or an array (numbers,
+// booleans, dates, inline tables) is kept as its raw text.
+//
+// This is only as much TOML as is needed to read dep's metadata files, which
+// is why there's no real TOML library in use.
+type tomlTable struct {
+	// The table's name, or empty for the top-level table
+	name string
+	// Whether the table is an element of an array of tables, i.e. [[name]]
+	array  bool
+	values map[string]interface{}
+}
+
+// str returns the string value of key, or the empty string if it's absent or
+// not a string.
+func (t *tomlTable) str(key string) string {
+	s, _ := t.values[key].(string)
+	return s
+}
+
+// strs returns the string elements of the array value of key.
+func (t *tomlTable) strs(key string) []string {
+	a, _ := t.values[key].([]interface{})
+	var l []string
+	for _, v := range a {
+		if s, ok := v.(string); ok {
+			l = append(l, s)
+		}
+	}
+	return l
+}
+
+type tomlParser struct {
+	data []byte
+	pos  int
+	line int
+}
+
+// parseTOML parses a TOML document into its tables, in the order they appear.
+// The top-level table is always first.
+func parseTOML(data []byte) ([]*tomlTable, error) {
+	p := &tomlParser{data: data, line: 1}
+	cur := &tomlTable{values: make(map[string]interface{})}
+	tables := []*tomlTable{cur}
+
+	for {
+		p.skipSpace(true)
+		if p.eof() {
+			return tables, nil
+		}
+
+		if p.peek() == '[' {
+			t, err := p.header()
+			if err != nil {
+				return nil, err
+			}
+			cur = t
+			tables = append(tables, t)
+		} else {
+			key, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			if _, has := cur.values[key]; has {
+				return nil, p.errorf("duplicate key %q", key)
+			}
+			if cur.values[key], err = p.value(); err != nil {
+				return nil, err
+			}
+		}
+
+		// Nothing but a comment may follow on the same line
+		p.skipSpace(false)
+		if !p.eof() && p.peek() != '\n' {
+			return nil, p.errorf("unexpected %q", p.peek())
+		}
+	}
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.data)
+}
+
+func (p *tomlParser) peek() byte {
+	return p.data[p.pos]
+}
+
+func (p *tomlParser) next() byte {
+	c := p.data[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %v: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// skipSpace skips whitespace and comments, including newlines if nl is true.
+func (p *tomlParser) skipSpace(nl bool) {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r':
+			p.next()
+		case '\n':
+			if !nl {
+				return
+			}
+			p.next()
+		case '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.next()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) header() (*tomlTable, error) {
+	t := &tomlTable{values: make(map[string]interface{})}
+	p.next()
+	if !p.eof() && p.peek() == '[' {
+		p.next()
+		t.array = true
+	}
+
+	end := bytes.IndexByte(p.data[p.pos:], ']')
+	if end < 0 {
+		return nil, p.errorf("unterminated table header")
+	}
+	t.name = strings.TrimSpace(string(p.data[p.pos : p.pos+end]))
+	p.pos += end + 1
+
+	if t.array {
+		if p.eof() || p.next() != ']' {
+			return nil, p.errorf("unterminated array of tables header")
+		}
+	}
+	if t.name == "" {
+		return nil, p.errorf("empty table name")
+	}
+	return t, nil
+}
+
+func (p *tomlParser) key() (string, error) {
+	var key string
+	if p.peek() == '"' || p.peek() == '\'' {
+		v, err := p.value()
+		if err != nil {
+			return "", err
+		}
+		key = v.(string)
+	} else {
+		start := p.pos
+		for !p.eof() && p.peek() != '=' && p.peek() != '\n' {
+			p.next()
+		}
+		key = strings.TrimSpace(string(p.data[start:p.pos]))
+	}
+
+	p.skipSpace(false)
+	if p.eof() || p.peek() != '=' {
+		return "", p.errorf("expected = after key %q", key)
+	}
+	p.next()
+	if key == "" {
+		return "", p.errorf("empty key")
+	}
+	return key, nil
+}
+
+func (p *tomlParser) value() (interface{}, error) {
+	p.skipSpace(false)
+	if p.eof() {
+		return nil, p.errorf("missing value")
+	}
+
+	switch p.peek() {
+	case '"':
+		return p.basicString()
+	case '\'':
+		p.next()
+		start := p.pos
+		for !p.eof() && p.peek() != '\'' {
+			if p.next() == '\n' {
+				return nil, p.errorf("unterminated string")
+			}
+		}
+		if p.eof() {
+			return nil, p.errorf("unterminated string")
+		}
+		s := string(p.data[start:p.pos])
+		p.next()
+		return s, nil
+	case '[':
+		p.next()
+		var a []interface{}
+		for {
+			p.skipSpace(true)
+			if p.eof() {
+				return nil, p.errorf("unterminated array")
+			}
+			if p.peek() == ']' {
+				p.next()
+				return a, nil
+			}
+
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+
+			p.skipSpace(true)
+			if !p.eof() && p.peek() == ',' {
+				p.next()
+			} else if p.eof() || p.peek() != ']' {
+				return nil, p.errorf("expected , or ] in array")
+			}
+		}
+	case '{':
+		// Inline tables aren't needed for anything, so just keep their text
+		start := p.pos
+		for !p.eof() && p.peek() != '}' && p.peek() != '\n' {
+			p.next()
+		}
+		if p.eof() || p.peek() != '}' {
+			return nil, p.errorf("unterminated inline table")
+		}
+		p.next()
+		return string(p.data[start:p.pos]), nil
+	}
+
+	start := p.pos
+	for !p.eof() && !strings.ContainsRune(" \t\r\n,]#", rune(p.peek())) {
+		p.next()
+	}
+	return string(p.data[start:p.pos]), nil
+}
+
+func (p *tomlParser) basicString() (string, error) {
+	if bytes.HasPrefix(p.data[p.pos:], []byte(`"""`)) {
+		return "", p.errorf("multi-line strings are not supported")
+	}
+
+	p.next()
+	var buf bytes.Buffer
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+
+		c := p.next()
+		switch c {
+		case '"':
+			return buf.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", p.errorf("unterminated string")
+			}
+			switch e := p.next(); e {
+			case '"', '\\':
+				buf.WriteByte(e)
+			case 'n':
+				buf.WriteByte('\n')
+			case 't':
+				buf.WriteByte('\t')
+			case 'r':
+				buf.WriteByte('\r')
+			default:
+				return "", p.errorf("unsupported escape \\%c", e)
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
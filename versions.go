@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/Masterminds/glide/dependency"
+	"github.com/sdboyer/gps"
+	"github.com/sdboyer/gta/sweep"
+	"github.com/spf13/cobra"
+)
+
+var VersionsCmd = &cobra.Command{
+	Use:   "versions <pkg>...",
+	Short: "List the available versions of deps, without checking anything",
+	Long: `versions lists the versions available for each given dependency, newest first,
+along with the kind of each (semver, version, branch, or revision) and the
+revision it points at. --semver, --branch, or --version narrow the list to the
+versions that match, as they would for a sweep.
+
+$ gta versions --semver ^1.0.0 github.com/foo/bar`,
+	RunE: RunVersions,
+}
+
+func RunVersions(cmd *cobra.Command, args []string) error {
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+
+	if len(args) == 0 {
+		return fmt.Errorf("You must specify at least one dependency to list the versions of.\n")
+	}
+	if retries < 0 {
+		return fmt.Errorf("--retries must not be negative")
+	}
+
+	c, err := parseConstraint(branch, version, semver)
+	if err != nil {
+		return err
+	}
+
+	base, err := newSourceManager()
+	if err != nil {
+		return err
+	}
+	defer base.Release()
+	var sm gps.SourceManager = base
+	if offline {
+		lsm := newLocalSourceManager(base, dependency.Analyzer{}, nil)
+		lsm.offline = sourceCacheDir()
+		defer lsm.release()
+		sm = lsm
+	}
+
+	seen := make(map[gps.ProjectRoot]bool)
+	for _, pkg := range args {
+		var root gps.ProjectRoot
+		err := sweep.Retry(retries, func() (err error) {
+			root, err = sm.DeduceProjectRoot(pkg)
+			return
+		})
+		if err != nil {
+			return fmt.Errorf("Could not detect source info for %s: %s", pkg, err)
+		}
+		if seen[root] {
+			continue
+		}
+		seen[root] = true
+
+		pi := gps.ProjectIdentifier{
+			ProjectRoot: root,
+		}
+		var vlist []gps.Version
+		err = sweep.Retry(retries, func() (err error) {
+			vlist, err = sm.ListVersions(pi)
+			return
+		})
+		if err != nil {
+			return fmt.Errorf("Could not retrieve version list for %s: %s", pi, err)
+		}
+		gps.SortForUpgrade(vlist)
+		vlist = sweep.UniqueVersions(vlist)
+
+		var vl []gps.Version
+		for _, v := range vlist {
+			if c.Matches(v) {
+				vl = append(vl, v)
+			}
+		}
+
+		if gps.IsAny(c) {
+			fmt.Fprintf(hout, "%s has %v versions:\n", root, len(vl))
+		} else {
+			fmt.Fprintf(hout, "%s has %v versions, %v of which match %s:\n", root, len(vlist), len(vl), c)
+		}
+
+		vcs := revVCS(pi, nil)
+		tw := tabwriter.NewWriter(hout, 0, 4, 2, ' ', 0)
+		for _, v := range vl {
+			switch tv := v.(type) {
+			case gps.Revision:
+				fmt.Fprintf(tw, "\t%s\trevision\t\n", shortRev(vcs, tv.String()))
+			case gps.PairedVersion:
+				fmt.Fprintf(tw, "\t%s\t%s\t%s\n", tv, tv.Type(), shortRev(vcs, tv.Underlying().String()))
+			case gps.UnpairedVersion:
+				fmt.Fprintf(tw, "\t%s\t%s\t\n", tv, tv.Type())
+			}
+		}
+		if err = tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
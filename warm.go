@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sdboyer/gps"
+	"github.com/sdboyer/gta/sweep"
+	"github.com/spf13/cobra"
+)
+
+var WarmCmd = &cobra.Command{
+	Use:   "warm <pkg>...",
+	Short: "Fetch all versions of deps, and their own deps, into the source cache",
+	Long: `warm fetches the source of each given dependency into the source cache, reads
+the metadata of every one of its versions, and then fetches the source of each
+project those versions depend on. Nothing is solved.
+
+Run before a big sweep (particularly one with --jobs), this means the solves
+don't stall on the network, and take a more predictable amount of time:
+
+$ gta warm github.com/foo/bar && gta -j 8 github.com/foo/bar`,
+	RunE: RunWarm,
+}
+
+func RunWarm(cmd *cobra.Command, args []string) error {
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+
+	if len(args) == 0 {
+		return fmt.Errorf("You must specify at least one dependency to warm.\n")
+	}
+	if retries < 0 {
+		return fmt.Errorf("--retries must not be negative")
+	}
+
+	sm, err := newSourceManager()
+	if err != nil {
+		return err
+	}
+	defer sm.Release()
+
+	// Deps of the targets are only fetched, once each, after all the targets'
+	// own versions have been gone through
+	var deps []gps.ProjectIdentifier
+	seen := make(map[gps.ProjectRoot]bool)
+	var nfail int
+	for _, pkg := range args {
+		var root gps.ProjectRoot
+		err := sweep.Retry(retries, func() (err error) {
+			root, err = sm.DeduceProjectRoot(pkg)
+			return
+		})
+		if err != nil {
+			return fmt.Errorf("Could not detect source info for %s: %s", pkg, err)
+		}
+		if seen[root] {
+			continue
+		}
+		seen[root] = true
+
+		pi := gps.ProjectIdentifier{
+			ProjectRoot: root,
+		}
+		fmt.Fprintf(hout, "Fetching %s...", root)
+		var vlist []gps.Version
+		err = sweep.Retry(retries, func() (err error) {
+			if err = sm.SyncSourceFor(pi); err != nil {
+				return
+			}
+			vlist, err = sm.ListVersions(pi)
+			return
+		})
+		if err != nil {
+			fmt.Fprintln(hout, "failed.")
+			return fmt.Errorf("Could not retrieve version list for %s: %s", pi, err)
+		}
+		fmt.Fprintf(hout, "%v versions.\n", len(vlist))
+
+		for _, v := range sweep.UniqueVersions(vlist) {
+			var m gps.Manifest
+			err := sweep.Retry(retries, func() (err error) {
+				m, _, err = sm.GetManifestAndLock(pi, v)
+				return
+			})
+			if err != nil {
+				fmt.Fprintf(hout, "\tcould not read %s at %s: %s\n", root, v, err)
+				nfail++
+				continue
+			}
+			if m == nil {
+				continue
+			}
+			for _, d := range m.DependencyConstraints() {
+				if !seen[d.Ident.ProjectRoot] {
+					seen[d.Ident.ProjectRoot] = true
+					deps = append(deps, d.Ident)
+				}
+			}
+		}
+	}
+
+	for _, id := range deps {
+		fmt.Fprintf(hout, "Fetching %s...", id.ProjectRoot)
+		err := sweep.Retry(retries, func() error {
+			return sm.SyncSourceFor(id)
+		})
+		if err != nil {
+			fmt.Fprintf(hout, "failed: %s\n", err)
+			nfail++
+			continue
+		}
+		fmt.Fprintln(hout, "ok.")
+	}
+
+	if nfail > 0 {
+		return fmt.Errorf("%v of the versions or deps could not be fetched; the cache is only partly warm", nfail)
+	}
+	return nil
+}